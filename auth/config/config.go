@@ -1,37 +1,126 @@
 package config
 
 import (
-	"errors"
-	"os"
-	"strconv"
+	"fmt"
+	"time"
 
+	"github.com/pavr1/people_project/shared/admin"
+	"github.com/pavr1/people_project/shared/configloader"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/ratelimit"
+	"github.com/pavr1/people_project/shared/secrets"
+	"github.com/pavr1/people_project/shared/tracing"
 	log "github.com/sirupsen/logrus"
 )
 
+// Config is loaded by configloader, see there for how settings map to files, environment
+// variables and flags.
 type Config struct {
+	Log struct {
+		// Level is a logrus level such as info or debug
+		Level string `mapstructure:"level" default:"debug" reload:"true"`
+		// Format is text or json
+		Format string `mapstructure:"format" default:"text" reload:"true"`
+		// ReportCaller adds the function and file of the code logging to each entry
+		ReportCaller bool `mapstructure:"report_caller" default:"true" reload:"true"`
+	} `mapstructure:"log"`
 	Server struct {
-		Port int `mapstructure:"port"`
+		Port int `mapstructure:"port" env:"AUTH_PORT" validate:"required,positive"`
 	} `mapstructure:"server"`
+	Tenant struct {
+		Default string `mapstructure:"default" env:"AUTH_DEFAULT_TENANT" default:"default" validate:"required"`
+	} `mapstructure:"tenant"`
+	Token struct {
+		// SigningKey is the PEM encoded Ed25519 private key tokens are signed with, from
+		// AUTH_SIGNING_KEY_FILE, the environment or the keystore. Its public key is served at
+		// /auth/jwks.
+		SigningKey secrets.Secret `mapstructure:"signing_key" env:"AUTH_SIGNING_KEY" validate:"required"`
+		// Issuer is the iss claim of the issued tokens
+		Issuer string `mapstructure:"issuer" env:"AUTH_TOKEN_ISSUER" default:"auth" validate:"required"`
+	} `mapstructure:"token"`
+	Roles struct {
+		// UsersFile is the JSON file mapping user names to their password hash, tenant, roles and
+		// groups, only its users get tokens
+		UsersFile string `mapstructure:"users_file" env:"AUTH_USERS_FILE" validate:"required"`
+		// Default are the roles of the users without roles of their own
+		Default []string `mapstructure:"default" env:"AUTH_DEFAULT_ROLES" default:"viewer"`
+	} `mapstructure:"roles"`
+	OpenApi struct {
+		Strict bool `mapstructure:"strict"`
+	} `mapstructure:"openapi"`
+	RateLimit struct {
+		// Default is the rate:burst limit of routes without their own limit
+		Default string `mapstructure:"default" default:"10:20" reload:"true"`
+		// Routes are comma separated route=rate:burst limits
+		Routes string `mapstructure:"routes" reload:"true"`
+		// TrustedProxies are comma separated CIDRs of the proxies whose X-Forwarded-For is believed
+		TrustedProxies string `mapstructure:"trusted_proxies"`
+		// MaxKeys caps the buckets kept in memory, the least recently used one is evicted first
+		MaxKeys int `mapstructure:"max_keys" default:"100000" validate:"positive"`
+	} `mapstructure:"rate_limit"`
+	Shutdown struct {
+		// Delay keeps serving after SIGTERM while readiness fails
+		Delay time.Duration `mapstructure:"delay" default:"5s"`
+		// Timeout bounds the wait for requests in flight
+		Timeout time.Duration `mapstructure:"timeout" default:"20s" validate:"positive"`
+	} `mapstructure:"shutdown"`
+	Tracing tracing.Config `mapstructure:"tracing"`
+	Admin   admin.Config   `mapstructure:"admin"`
 }
 
-func NewConfig(log *log.Logger) (*Config, error) {
-	// Unmarshal the configuration into a struct
-	port := os.Getenv("AUTH_PORT")
-	if port == "" {
-		log.Error("AUTH_PORT is not set")
-		return nil, errors.New("AUTH_PORT is not set")
-	}
+// Options are the loader options NewConfig(log, args) uses, for reloading the same configuration.
+func Options(args []string) configloader.Options {
+	return configloader.Options{Name: "auth", Args: args}
+}
+
+// NewConfig loads the configuration from the defaults, the optional config file, the environment
+// and args, the command line flags.
+func NewConfig(log *log.Logger, args []string) (*Config, error) {
+	var config = Config{}
 
-	portInt, err := strconv.Atoi(port)
+	err := configloader.Load(&config, Options(args))
 	if err != nil {
-		log.WithField("error", err).Error("Failed to convert port to int")
 		return nil, err
 	}
 
-	var config = Config{}
-	config.Server.Port = portInt
-
-	log.WithField("config", config).Info("Loaded configuration file")
+	log.WithField("config", configloader.Summary(config)).Info("Loaded configuration file")
 
 	return &config, nil
 }
+
+// Validate checks the settings the loader cannot check by their type.
+func (c *Config) Validate() []error {
+	problems := []error{}
+
+	_, err := log.ParseLevel(c.Log.Level)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("log.level (LOG_LEVEL): %w", err))
+	}
+
+	err = logging.ValidateFormat(c.Log.Format)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("log.format (LOG_FORMAT): %w", err))
+	}
+
+	_, err = ratelimit.ParseOptions(c.RateLimit.Default, c.RateLimit.Routes)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("rate_limit (RATE_LIMIT_DEFAULT, RATE_LIMIT_ROUTES): %w", err))
+	}
+
+	_, err = ratelimit.ParseCIDRs(c.RateLimit.TrustedProxies)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("rate_limit.trusted_proxies (RATE_LIMIT_TRUSTED_PROXIES): %w", err))
+	}
+
+	err = c.Tracing.Validate()
+	if err != nil {
+		problems = append(problems, fmt.Errorf("tracing (TRACING_EXPORTER, TRACING_SAMPLE_RATIO): %w", err))
+	}
+
+	err = c.Admin.Validate()
+	if err != nil {
+		problems = append(problems, fmt.Errorf("admin (ADMIN_PORT, ADMIN_TOKEN): %w", err))
+	}
+
+	return problems
+}
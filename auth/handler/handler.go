@@ -1,86 +1,268 @@
 package handler
 
 import (
+	"crypto/ed25519"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/pavr1/people_project/people_project/auth/config"
+	"github.com/pavr1/people_project/shared/logging"
 )
 
+// tenantPattern keeps tenant names safe to use as part of a collection name.
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Roles understood by the people service
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Claims are the claims of every token this service issues.
+type Claims struct {
+	UserName string   `json:"username"`
+	Tenant   string   `json:"tenant"`
+	Roles    []string `json:"roles"`
+	Groups   []string `json:"groups,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// User is an entry of the users file.
+type User struct {
+	// PasswordHash is the bcrypt hash of the password of the user, e.g. from
+	// `htpasswd -nbBC 12 "" <password> | tr -d ':\n'`. Users without one cannot get tokens.
+	PasswordHash string `json:"password_hash"`
+	// Tenant is the only tenant tokens of the user are issued for, the default tenant when empty
+	Tenant string `json:"tenant"`
+	// Roles are the default roles when empty
+	Roles  []string `json:"roles"`
+	Groups []string `json:"groups"`
+}
+
+// unknownUserHash is compared with the passwords of unknown users, so they take as long to
+// reject as wrong passwords and do not tell which user names exist.
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+
 type Handler struct {
-	secretKey []byte
-	log       *log.Logger
+	signingKey    ed25519.PrivateKey
+	jwk           JWK
+	issuer        string
+	defaultTenant string
+	defaultRoles  []string
+	users         map[string]User
+	log           *log.Logger
 }
 
-func NewHandler(log *log.Logger, secretKey []byte) *Handler {
+func NewHandler(log *log.Logger, config *config.Config) (*Handler, error) {
+	signingKey, err := ParseSigningKey(config.Token.SigningKey)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse signing key")
+		return nil, err
+	}
+
+	users := map[string]User{}
+
+	if config.Roles.UsersFile != "" {
+		data, err := os.ReadFile(config.Roles.UsersFile)
+		if err != nil {
+			log.WithError(err).Error("Failed to read users file")
+			return nil, err
+		}
+
+		err = json.Unmarshal(data, &users)
+		if err != nil {
+			log.WithError(err).Error("Failed to parse users file")
+			return nil, err
+		}
+	}
+
+	for name, user := range users {
+		err := validateRoles(user.Roles)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+
+		if user.Tenant != "" && !tenantPattern.MatchString(user.Tenant) {
+			return nil, fmt.Errorf("user %s: invalid tenant %q", name, user.Tenant)
+		}
+
+		if user.PasswordHash == "" {
+			log.WithField("user", name).Warn("User has no password hash and cannot get tokens")
+
+			continue
+		}
+
+		_, err = bcrypt.Cost([]byte(user.PasswordHash))
+		if err != nil {
+			return nil, fmt.Errorf("user %s: invalid password hash: %w", name, err)
+		}
+	}
+
+	err = validateRoles(config.Roles.Default)
+	if err != nil {
+		return nil, fmt.Errorf("default roles: %w", err)
+	}
+
+	if !tenantPattern.MatchString(config.Tenant.Default) {
+		return nil, fmt.Errorf("invalid default tenant %q", config.Tenant.Default)
+	}
+
 	return &Handler{
-		secretKey: secretKey,
-		log:       log,
+		signingKey:    signingKey,
+		jwk:           newJWK(signingKey.Public().(ed25519.PublicKey)),
+		issuer:        config.Token.Issuer,
+		defaultTenant: config.Tenant.Default,
+		defaultRoles:  config.Roles.Default,
+		users:         users,
+		log:           log,
+	}, nil
+}
+
+func validateRoles(roles []string) error {
+	for _, role := range roles {
+		if role != RoleViewer && role != RoleEditor && role != RoleAdmin {
+			return fmt.Errorf("unknown role %q", role)
+		}
 	}
+
+	return nil
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), h.log)
+
 	if r.Method == http.MethodGet {
-		log.Info("Handling GET request")
+		logger.Info("Handling GET request")
 
-		tokenString := r.Header.Get("Authorization")
-		if tokenString == "" {
+		tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="auth"`)
 			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprint(w, "Missing authorization header")
-			log.Warn("Missing authorization header")
+			fmt.Fprint(w, "Missing bearer token")
+			logger.Warn("Missing bearer token")
 			return
 		}
-		tokenString = tokenString[len("Bearer "):]
 
-		err := h.verifyToken(tokenString)
+		claims, err := h.verifyToken(tokenString)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			fmt.Fprint(w, err.Error())
-			log.Warn(err)
+			logger.WithError(err).Warn("Invalid token")
+			return
+		}
+
+		body, err := json.Marshal(claims)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			logger.WithError(err).Error("Failed to marshal claims")
 			return
 		}
 
-		log.Info("Token verified")
+		logger.Info("Token verified")
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+		w.Write(body)
 	} else if r.Method == http.MethodPost {
-		log.Info("Handling POST request")
-		userName := r.Header.Get("X-User-Name")
+		logger.Info("Handling POST request")
+		userName, password, ok := r.BasicAuth()
 
-		if userName == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("missing X-User-Name"))
-			log.Warn("Missing X-User-Name")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("missing credentials"))
+			logger.Warn("Missing credentials")
 			return
 		}
 
-		token, err := h.createToken(userName)
+		user, ok := h.authenticate(userName, password)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid user name or password"))
+			logger.WithField("user", userName).Warn("Invalid credentials")
+			return
+		}
+
+		// The tenant is the user's, X-Tenant only asserts which one the caller expects
+		tenant := r.Header.Get("X-Tenant")
+		if tenant != "" && tenant != user.Tenant {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("X-Tenant is not the tenant of the user"))
+			logger.WithFields(log.Fields{"user": userName, "tenant": tenant}).Warn("Token requested for another tenant")
+			return
+		}
+
+		token, err := h.createToken(userName, user)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			log.Error(err)
+			logger.WithError(err).Error("Failed to create token")
 			return
 		}
 
-		log.Info("Token created")
+		logger.Info("Token created")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(token))
 	} else {
-		log.Info("Handling unsupported request")
+		logger.Info("Handling unsupported request")
 
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func (h *Handler) createToken(username string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.MapClaims{
-			"username": username,
-			"exp":      time.Now().Add(time.Minute * 5).Unix(),
+// authenticate checks the password of a user of the users file and returns the user with the
+// default tenant and roles applied.
+func (h *Handler) authenticate(username string, password string) (User, bool) {
+	user, ok := h.users[username]
+
+	hash := []byte(user.PasswordHash)
+	if !ok || user.PasswordHash == "" {
+		hash = unknownUserHash
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if err != nil || !ok || user.PasswordHash == "" {
+		return User{}, false
+	}
+
+	if user.Tenant == "" {
+		user.Tenant = h.defaultTenant
+	}
+
+	if len(user.Roles) == 0 {
+		user.Roles = h.defaultRoles
+	}
+
+	return user, true
+}
+
+func (h *Handler) createToken(username string, user User) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA,
+		Claims{
+			UserName: username,
+			Tenant:   user.Tenant,
+			Roles:    user.Roles,
+			Groups:   user.Groups,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    h.issuer,
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute * 5)),
+			},
 		})
+	token.Header["kid"] = h.jwk.Kid
 
-	tokenString, err := token.SignedString(h.secretKey)
+	tokenString, err := token.SignedString(h.signingKey)
 	if err != nil {
 		return "", err
 	}
@@ -88,18 +270,20 @@ func (h *Handler) createToken(username string) (string, error) {
 	return tokenString, nil
 }
 
-func (h *Handler) verifyToken(tokenString string) error {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return h.secretKey, nil
-	})
+func (h *Handler) verifyToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return h.signingKey.Public(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}), jwt.WithIssuer(h.issuer), jwt.WithExpirationRequired())
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
 	}
 
-	return nil
+	return claims, nil
 }
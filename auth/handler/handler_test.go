@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	_config "github.com/pavr1/people_project/people_project/auth/config"
+	"github.com/pavr1/people_project/shared/secrets"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHash is the password hash of the test users, their password is "secret"
+var testHash = func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+
+	return string(hash)
+}()
+
+// testUsers is a users file, HASH is replaced by testHash.
+func testUsers(users string) string {
+	return strings.ReplaceAll(users, "HASH", testHash)
+}
+
+// newTestHandler returns a handler with a new signing key and users, a users file entry each.
+func newTestHandler(t *testing.T, users string) *Handler {
+	t.Helper()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersFile := filepath.Join(t.TempDir(), "users.json")
+
+	err = os.WriteFile(usersFile, []byte(testUsers(users)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &_config.Config{}
+	config.Token.SigningKey = secrets.New(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	config.Token.Issuer = "auth"
+	config.Tenant.Default = "default"
+	config.Roles.Default = []string{RoleViewer}
+	config.Roles.UsersFile = usersFile
+
+	handler, err := NewHandler(logger, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return handler
+}
+
+func TestTokenTenant(t *testing.T) {
+	handler := newTestHandler(t, `{
+		"alice": {"password_hash": "HASH", "tenant": "acme", "roles": ["admin"]},
+		"bob": {"password_hash": "HASH", "roles": ["editor"]}
+	}`)
+
+	tests := []struct {
+		name       string
+		user       string
+		tenant     string
+		status     int
+		wantTenant string
+	}{
+		{name: "tenant of the user", user: "alice", status: http.StatusOK, wantTenant: "acme"},
+		{name: "tenant of the user asserted", user: "alice", tenant: "acme", status: http.StatusOK, wantTenant: "acme"},
+		{name: "another tenant", user: "alice", tenant: "default", status: http.StatusForbidden},
+		{name: "user without tenant", user: "bob", status: http.StatusOK, wantTenant: "default"},
+		{name: "user without tenant asking for another", user: "bob", tenant: "acme", status: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/auth/token", nil)
+			request.SetBasicAuth(test.user, "secret")
+			if test.tenant != "" {
+				request.Header.Set("X-Tenant", test.tenant)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d, body %q", w.Code, test.status, w.Body.String())
+			}
+
+			if test.status != http.StatusOK {
+				return
+			}
+
+			claims, err := handler.verifyToken(w.Body.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Tenant != test.wantTenant {
+				t.Errorf("tenant = %q, want %q", claims.Tenant, test.wantTenant)
+			}
+		})
+	}
+}
+
+func TestTokenRequiresCredentials(t *testing.T) {
+	handler := newTestHandler(t, `{
+		"alice": {"password_hash": "HASH", "roles": ["admin"], "groups": ["hr"]},
+		"bob": {"password_hash": "HASH"},
+		"mallory": {"roles": ["admin"]}
+	}`)
+
+	tests := []struct {
+		name      string
+		user      string
+		password  string
+		status    int
+		wantRoles []string
+	}{
+		{name: "no credentials", status: http.StatusUnauthorized},
+		{name: "wrong password", user: "alice", password: "guess", status: http.StatusUnauthorized},
+		{name: "unknown user", user: "eve", password: "secret", status: http.StatusUnauthorized},
+		{name: "user without password hash", user: "mallory", password: "", status: http.StatusUnauthorized},
+		{name: "roles of the user", user: "alice", password: "secret", status: http.StatusOK, wantRoles: []string{RoleAdmin}},
+		{name: "default roles", user: "bob", password: "secret", status: http.StatusOK, wantRoles: []string{RoleViewer}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/auth/token", nil)
+			if test.user != "" {
+				request.SetBasicAuth(test.user, test.password)
+			}
+
+			// The user name header of earlier versions grants nothing
+			request.Header.Set("X-User-Name", "alice")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d, body %q", w.Code, test.status, w.Body.String())
+			}
+
+			if test.status != http.StatusOK {
+				if w.Header().Get("WWW-Authenticate") == "" {
+					t.Error("WWW-Authenticate is missing")
+				}
+
+				return
+			}
+
+			claims, err := handler.verifyToken(w.Body.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.UserName != test.user || !reflect.DeepEqual(claims.Roles, test.wantRoles) {
+				t.Errorf("claims are user %q with roles %v, want %q with %v", claims.UserName, claims.Roles, test.user, test.wantRoles)
+			}
+		})
+	}
+}
+
+func TestVerifyRequiresBearerToken(t *testing.T) {
+	handler := newTestHandler(t, `{"alice": {"password_hash": "HASH", "roles": ["admin"]}}`)
+
+	request := httptest.NewRequest(http.MethodPost, "/auth/token", nil)
+	request.SetBasicAuth("alice", "secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	token := w.Body.String()
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "no header", status: http.StatusUnauthorized},
+		{name: "shorter than the scheme", authorization: "Bear", status: http.StatusUnauthorized},
+		{name: "another scheme", authorization: "Basic " + token, status: http.StatusUnauthorized},
+		{name: "scheme of the same length", authorization: "Token: " + token, status: http.StatusUnauthorized},
+		{name: "invalid token", authorization: "Bearer x", status: http.StatusUnauthorized},
+		{name: "bearer token", authorization: "Bearer " + token, status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/auth/token", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d, body %q", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestNewHandlerRejectsInvalidTenant(t *testing.T) {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	usersFile := filepath.Join(t.TempDir(), "users.json")
+
+	err := os.WriteFile(usersFile, []byte(`{"alice": {"tenant": "../admin"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	config := &_config.Config{}
+	config.Token.SigningKey = secrets.New(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	config.Tenant.Default = "default"
+	config.Roles.UsersFile = usersFile
+
+	_, err = NewHandler(logger, config)
+	if err == nil {
+		t.Error("NewHandler accepted a user with an invalid tenant")
+	}
+}
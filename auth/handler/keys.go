@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/secrets"
+)
+
+// JWK is the public half of the signing key in the JSON Web Key format (RFC 8037).
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// JWKS is the key set services fetch from /auth/jwks to verify tokens themselves.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParseSigningKey reads a PEM encoded PKCS #8 Ed25519 private key, as made by
+// `openssl genpkey -algorithm ed25519`.
+func ParseSigningKey(secret secrets.Secret) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(secret.Reveal()))
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an Ed25519 key")
+	}
+
+	return privateKey, nil
+}
+
+// newJWK describes publicKey, its key ID is the RFC 7638 thumbprint so it changes with the key.
+func newJWK(publicKey ed25519.PublicKey) JWK {
+	x := base64.RawURLEncoding.EncodeToString(publicKey)
+	thumbprint := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + x + `"}`))
+
+	return JWK{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   x,
+		Kid: base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+		Alg: "EdDSA",
+		Use: "sig",
+	}
+}
+
+// ServeJWKS returns the public key tokens are signed with. Verifiers cache it, a key they do not
+// know makes them fetch it again.
+func (h *Handler) ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(JWKS{Keys: []JWK{h.jwk}})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		logging.FromContext(r.Context(), h.log).WithError(err).Error("Failed to marshal key set")
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
-	"github.com/pavr1/people_project/people_project/auth/config"
+	_config "github.com/pavr1/people_project/people_project/auth/config"
 	"github.com/pavr1/people_project/people_project/auth/handler"
+	"github.com/pavr1/people_project/people_project/auth/openapi"
+	"github.com/pavr1/people_project/shared/admin"
+	"github.com/pavr1/people_project/shared/configloader"
+	"github.com/pavr1/people_project/shared/health"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/middleware"
+	"github.com/pavr1/people_project/shared/ratelimit"
+	_server "github.com/pavr1/people_project/shared/server"
+	"github.com/pavr1/people_project/shared/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -14,40 +27,136 @@ import (
 func main() {
 	log.Info("Starting AuthServer...")
 
-	log := setupLogger()
-	config, err := config.NewConfig(log)
+	log, logSettings := setupLogger()
+	config, err := _config.NewConfig(log, os.Args[1:])
+	if errors.Is(err, configloader.ErrPrinted) {
+		return
+	}
+
 	if err != nil {
 		log.WithError(err).Error("Error loading config")
+		os.Exit(1)
+	}
+
+	logSettings.Configure(config.Log.Level, config.Log.Format, config.Log.ReportCaller)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), log, "auth", config.Tracing)
+	if err != nil {
+		log.WithError(err).Error("Failed to set up tracing")
 		return
 	}
 
 	router := http.NewServeMux()
-	//need to add this secret to a secret so it can be retrieved from there
-	secret := "test"
-	authHandler := handler.NewHandler(log, []byte(secret))
+	authHandler, err := handler.NewHandler(log, config)
+	if err != nil {
+		log.WithError(err).Error("Failed to create auth handler")
+		return
+	}
+
+	// The auth service has no dependencies, it is ready until it shuts down
+	checker := health.NewChecker(log, 0, 0)
+
+	openApiHandler, err := openapi.NewOpenApiHandler(log, config)
+	if err != nil {
+		log.WithError(err).Error("Failed to create openapi handler")
+		return
+	}
+
+	rateLimitOptions, err := ratelimit.ParseOptions(config.RateLimit.Default, config.RateLimit.Routes)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse rate limits")
+		return
+	}
+
+	rateLimitOptions.TrustedProxies, err = ratelimit.ParseCIDRs(config.RateLimit.TrustedProxies)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse trusted proxies")
+		return
+	}
+
+	limiter := ratelimit.NewLimiter(log, ratelimit.NewMemoryBackend(10*time.Minute, config.RateLimit.MaxKeys), rateLimitOptions)
 
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+	// Every route is rate limited by client IP and validated, the token routes are traced and logged as well
+	base := middleware.New(middleware.Recover(log), limiter.Middleware, openApiHandler.Middleware)
+	api := middleware.New(tracing.Middleware(middleware.Path), logging.Middleware(log), middleware.Log(log, middleware.Path)).Append(base...)
+
+	router.Handle("/livez", base.ThenFunc(checker.Live))
+	router.Handle("/readyz", base.ThenFunc(checker.Ready))
+	router.Handle("/metrics", base.Then(promhttp.Handler()))
+	router.Handle("/openapi.json", base.ThenFunc(openApiHandler.ServeSpec))
+	router.Handle("/docs", base.ThenFunc(openApiHandler.ServeDocs))
+
+	router.Handle("/auth/token", api.ThenFunc(authHandler.ServeHTTP))
+	router.Handle("/auth/jwks", api.ThenFunc(authHandler.ServeJWKS))
+
+	// log.* and rate_limit.* are reloadable, the rest needs a restart
+	reloader, err := configloader.NewReloader(log, config, configloader.ReloadOptions[_config.Config]{
+		Options: _config.Options(os.Args[1:]),
+		Apply: func(previous *_config.Config, next *_config.Config) {
+			logSettings.Configure(next.Log.Level, next.Log.Format, next.Log.ReportCaller)
+
+			rateLimitOptions, err := ratelimit.ParseOptions(next.RateLimit.Default, next.RateLimit.Routes)
+			if err != nil {
+				log.WithError(err).Error("Failed to parse reloaded rate limits")
+
+				return
+			}
+
+			limiter.SetLimits(rateLimitOptions.Default, rateLimitOptions.Routes)
+		},
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to create config reloader")
+		return
+	}
+
+	reloadCtx, stopReloading := context.WithCancel(context.Background())
+	go reloader.Run(reloadCtx)
+
+	adminServer := admin.NewServer(log, config.Admin, admin.Options{
+		Service:  "auth",
+		Settings: logSettings,
+		Config:   func() interface{} { return reloader.Current() },
 	})
+	go adminServer.Run(reloadCtx)
 
-	router.HandleFunc("/auth/token", authHandler.ServeHTTP)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Server.Port),
+		Handler: router,
+	}
 
 	log.WithField("port", config.Server.Port).Info("Listening to AuthServer...")
-	// Start the HTTP server
-	log.Error(http.ListenAndServe(fmt.Sprintf(":%d", config.Server.Port), router))
+	// Start the HTTP server and drain it on SIGINT or SIGTERM
+	err = _server.Run(log, server, _server.Shutdown{
+		Delay:   config.Shutdown.Delay,
+		Timeout: config.Shutdown.Timeout,
+		Checker: checker,
+	})
+	if err != nil {
+		log.WithError(err).Error("AuthServer failed")
+	}
+
+	stopReloading()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Shutdown.Timeout)
+	defer cancel()
+
+	err = shutdownTracing(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to flush traces")
+	}
 }
 
-func setupLogger() *log.Logger {
+func setupLogger() (*log.Logger, *logging.Settings) {
 	logger := log.New()
-	logger.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-	})
-
-	logger.SetReportCaller(true)
-	logger.SetLevel(log.DebugLevel)
+	logger.AddHook(tracing.LogHook{})
 
 	// Set the output to stdout
 	logger.SetOutput(os.Stdout)
 
-	return logger
+	// Until the config is loaded
+	settings := logging.NewSettings(logger)
+	settings.Configure("info", logging.FormatText, true)
+
+	return logger, settings
 }
@@ -0,0 +1,22 @@
+// Package openapi holds the OpenAPI specification of the auth service, served and enforced by
+// shared/openapi.
+package openapi
+
+import (
+	_ "embed"
+
+	_config "github.com/pavr1/people_project/people_project/auth/config"
+	_openapi "github.com/pavr1/people_project/shared/openapi"
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+func NewOpenApiHandler(log *log.Logger, config *_config.Config) (*_openapi.Handler, error) {
+	return _openapi.NewHandler(log, _openapi.Options{
+		Title:  "Auth API",
+		Spec:   spec,
+		Strict: config.OpenApi.Strict,
+	})
+}
@@ -0,0 +1,139 @@
+package openapi
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_config "github.com/pavr1/people_project/people_project/auth/config"
+	"github.com/pavr1/people_project/people_project/auth/handler"
+	"github.com/pavr1/people_project/shared/secrets"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestServer serves the auth routes behind the strict validation of the specification, a
+// handler drifting from it answers 500.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersFile := filepath.Join(t.TempDir(), "users.json")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(usersFile, []byte(`{"alice": {"password_hash": "`+string(hash)+`", "tenant": "acme", "roles": ["admin"]}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &_config.Config{}
+	config.Roles.UsersFile = usersFile
+	config.Token.SigningKey = secrets.New(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	config.Token.Issuer = "auth"
+	config.Tenant.Default = "default"
+	config.Roles.Default = []string{"viewer"}
+	config.OpenApi.Strict = true
+
+	authHandler, err := handler.NewHandler(logger, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	openApiHandler, err := NewOpenApiHandler(logger, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := http.NewServeMux()
+	router.Handle("/auth/token", openApiHandler.Middleware(http.HandlerFunc(authHandler.ServeHTTP)))
+	router.Handle("/auth/jwks", openApiHandler.Middleware(http.HandlerFunc(authHandler.ServeJWKS)))
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestHandlersMatchSpecification(t *testing.T) {
+	server := newTestServer(t)
+
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/auth/token", nil)
+	request.SetBasicAuth("alice", "secret")
+
+	token := call(t, request, http.StatusOK)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		basic   bool
+		status  int
+	}{
+		{name: "verify token", method: http.MethodGet, path: "/auth/token", headers: map[string]string{"Authorization": "Bearer " + token}, status: http.StatusOK},
+		{name: "verify invalid token", method: http.MethodGet, path: "/auth/token", headers: map[string]string{"Authorization": "Bearer invalid"}, status: http.StatusUnauthorized},
+		{name: "verify without token", method: http.MethodGet, path: "/auth/token", status: http.StatusUnauthorized},
+		{name: "create token without credentials", method: http.MethodPost, path: "/auth/token", status: http.StatusUnauthorized},
+		{name: "create token for invalid tenant", method: http.MethodPost, path: "/auth/token", headers: map[string]string{"X-Tenant": "no tenant"}, basic: true, status: http.StatusBadRequest},
+		{name: "create token for another tenant", method: http.MethodPost, path: "/auth/token", headers: map[string]string{"X-Tenant": "default"}, basic: true, status: http.StatusForbidden},
+		{name: "create token for the user's tenant", method: http.MethodPost, path: "/auth/token", headers: map[string]string{"X-Tenant": "acme"}, basic: true, status: http.StatusOK},
+		{name: "key set", method: http.MethodGet, path: "/auth/jwks", status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, _ := http.NewRequest(test.method, server.URL+test.path, nil)
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+
+			if test.basic {
+				request.SetBasicAuth("alice", "secret")
+			}
+
+			call(t, request, test.status)
+		})
+	}
+}
+
+// call sends request and checks the status of the response, returning its body.
+func call(t *testing.T, request *http.Request, status int) string {
+	t.Helper()
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+
+	if response.StatusCode != status {
+		t.Fatalf("%s %s: status = %d, want %d, body %q", request.Method, request.URL.Path, response.StatusCode, status, body)
+	}
+
+	return string(body)
+}
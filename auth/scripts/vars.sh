@@ -2,7 +2,25 @@
 
 # Set the environment variables
 VARIABLES=(
+  "LOG_LEVEL=debug"
+  "LOG_FORMAT=json"
   "AUTH_PORT=8081"
+  "AUTH_DEFAULT_TENANT=default"
+  "AUTH_DEFAULT_ROLES=viewer"
+  "AUTH_USERS_FILE=/var/run/secrets/auth-users/users.json"
+  "AUTH_SIGNING_KEY_FILE=/var/run/secrets/auth/signing-key"
+  "AUTH_TOKEN_ISSUER=auth"
+  "RATE_LIMIT_DEFAULT=10:20"
+  "RATE_LIMIT_ROUTES=/livez=0:0,/readyz=0:0"
+  "RATE_LIMIT_TRUSTED_PROXIES=10.0.0.0/8"
+  "RATE_LIMIT_MAX_KEYS=100000"
+  "SHUTDOWN_DELAY=5s"
+  "SHUTDOWN_TIMEOUT=20s"
+  "TRACING_EXPORTER=otlp"
+  "TRACING_ENDPOINT=http://otel-collector:4318"
+  "TRACING_SAMPLE_RATIO=1"
+  "LOG_REPORT_CALLER=true"
+  "ADMIN_PORT=6060"
 )
 
 echo "Setting environment variables in namespace $1"
@@ -12,4 +30,7 @@ for variable in "${VARIABLES[@]}"; do
   kubectl -n $1 set env deployment/auth ${variable}
 done
 
+# The admin token is only readable from its secret, see make admin-token
+kubectl -n $1 set env deployment/auth --from=secret/auth-admin-token
+
 echo "Done!"
\ No newline at end of file
@@ -0,0 +1,212 @@
+// Package client is a typed Go client for the people and auth services.
+//
+// It fetches a token from the auth service on first use and refreshes it shortly before it
+// expires, retries requests that fail with a 5xx status or a transport error, and reports
+// non-2xx responses as *APIError values that can be matched with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = 200 * time.Millisecond
+	defaultRefreshBefore = 30 * time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+type Config struct {
+	// PeopleURL is the base URL of the people service, e.g. http://people:8080.
+	PeopleURL string
+	// AuthURL is the base URL of the auth service, e.g. http://auth:8081.
+	AuthURL string
+	// UserName and Password authenticate the client when requesting a token. Without a password
+	// only a token seeded with SetToken can be used.
+	UserName string
+	Password string
+	// Tenant is sent as X-Tenant when requesting a token, which fails unless it is the tenant of the
+	// user. Empty accepts the tenant of the user.
+	Tenant string
+	// HttpClient is used for every request. Defaults to a client with a 10s timeout.
+	HttpClient *http.Client
+	// MaxRetries is the number of extra attempts for retryable requests. Defaults to 3.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry; it doubles on every attempt. Defaults to 200ms.
+	RetryBackoff time.Duration
+	// RefreshBefore is how long before expiry a token is replaced. Defaults to 30s.
+	RefreshBefore time.Duration
+}
+
+type Client struct {
+	config Config
+	tokens *tokenSource
+}
+
+func NewClient(config Config) (*Client, error) {
+	if config.PeopleURL == "" {
+		return nil, errors.New("PeopleURL is required")
+	}
+
+	if config.AuthURL == "" {
+		return nil, errors.New("AuthURL is required")
+	}
+
+	if config.UserName == "" {
+		return nil, errors.New("UserName is required")
+	}
+
+	if config.HttpClient == nil {
+		config.HttpClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = defaultMaxRetries
+	}
+
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = defaultRetryBackoff
+	}
+
+	if config.RefreshBefore == 0 {
+		config.RefreshBefore = defaultRefreshBefore
+	}
+
+	config.PeopleURL = strings.TrimSuffix(config.PeopleURL, "/")
+	config.AuthURL = strings.TrimSuffix(config.AuthURL, "/")
+
+	c := &Client{config: config}
+	c.tokens = newTokenSource(c)
+
+	return c, nil
+}
+
+// Token returns the current token, fetching a new one if it is missing or about to expire.
+func (c *Client) Token(ctx context.Context) (string, error) {
+	return c.tokens.Token(ctx)
+}
+
+// SetToken seeds the cache with a previously issued token, for example one persisted between
+// CLI invocations. It is replaced like any other token once it nears expiry.
+func (c *Client) SetToken(token string) error {
+	expiry, err := tokenExpiry(token)
+	if err != nil {
+		return err
+	}
+
+	c.tokens.set(token, expiry)
+
+	return nil
+}
+
+// request is a single call to one of the services.
+type request struct {
+	method  string
+	url     string
+	header  http.Header
+	body    []byte
+	retry   bool
+	noAuth  bool
+	expects int
+}
+
+// response is a fully read response.
+type response struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+// do sends the request, attaching a bearer token unless noAuth is set. A 401 invalidates the
+// cached token and is retried once with a fresh one; 5xx and transport errors are retried
+// with exponential backoff when retry is set.
+func (c *Client) do(ctx context.Context, req request) (*response, error) {
+	refreshed := false
+
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, req.url, bytes.NewReader(req.body))
+		if err != nil {
+			return nil, err
+		}
+
+		for key, values := range req.header {
+			httpReq.Header[key] = values
+		}
+
+		if req.body != nil {
+			httpReq.Header.Set("Content-Type", "application/json")
+		}
+
+		if !req.noAuth {
+			token, err := c.tokens.Token(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			httpReq.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := c.send(httpReq)
+		if err != nil {
+			if ctx.Err() != nil || !req.retry || attempt >= c.config.MaxRetries {
+				return nil, err
+			}
+		} else if resp.statusCode == http.StatusUnauthorized && !req.noAuth && !refreshed {
+			c.tokens.invalidate()
+			refreshed = true
+			attempt--
+
+			continue
+		} else if resp.statusCode < http.StatusInternalServerError || !req.retry || attempt >= c.config.MaxRetries {
+			if resp.statusCode != req.expects {
+				return resp, newAPIError(resp.statusCode, string(resp.body))
+			}
+
+			return resp, nil
+		}
+
+		err = c.wait(ctx, attempt)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) send(req *http.Request) (*response, error) {
+	resp, err := c.config.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response{
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+		body:       body,
+	}, nil
+}
+
+// wait sleeps for the backoff of the given attempt or until the context is done.
+func (c *Client) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(c.config.RetryBackoff << attempt)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,399 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testToken returns an unsigned JWT expiring at expiry, the client only reads its exp claim.
+func testToken(expiry time.Time) string {
+	encode := func(value string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+
+	return encode(`{"alg":"EdDSA"}`) + "." + encode(fmt.Sprintf(`{"exp":%d}`, expiry.Unix())) + "." + encode("signature")
+}
+
+// testAuth is an in-process auth service issuing a new token per request.
+type testAuth struct {
+	server   *httptest.Server
+	requests atomic.Int32
+	mu       sync.Mutex
+	issued   []string
+	header   http.Header
+	expiry   time.Duration
+}
+
+func newTestAuth(t *testing.T) *testAuth {
+	t.Helper()
+
+	auth := &testAuth{expiry: 5 * time.Minute}
+	auth.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/auth/token" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		auth.requests.Add(1)
+
+		// Tokens of the same second would be equal, the count tells them apart
+		token := testToken(time.Now().Add(auth.expiry).Add(time.Duration(auth.requests.Load()) * time.Second))
+
+		auth.mu.Lock()
+		auth.issued = append(auth.issued, token)
+		auth.header = r.Header.Clone()
+		auth.mu.Unlock()
+
+		w.Write([]byte(token))
+	}))
+	t.Cleanup(auth.server.Close)
+
+	return auth
+}
+
+func (a *testAuth) lastIssued() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.issued[len(a.issued)-1]
+}
+
+// testPeople is an in-process people service answering with the given statuses in turn and
+// 200 once they are used up.
+type testPeople struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	body     string
+}
+
+func newTestPeople(t *testing.T, statuses ...int) *testPeople {
+	t.Helper()
+
+	people := &testPeople{statuses: statuses, body: `{}`}
+	people.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		people.mu.Lock()
+		people.requests = append(people.requests, r.Clone(context.Background()))
+
+		status := http.StatusOK
+		if len(people.statuses) > 0 {
+			status, people.statuses = people.statuses[0], people.statuses[1:]
+		}
+		people.mu.Unlock()
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			w.Write([]byte(http.StatusText(status)))
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(people.body))
+	}))
+	t.Cleanup(people.server.Close)
+
+	return people
+}
+
+func (p *testPeople) received() []*http.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*http.Request{}, p.requests...)
+}
+
+func newTestClient(t *testing.T, auth *testAuth, people *testPeople) *Client {
+	t.Helper()
+
+	client, err := NewClient(Config{
+		PeopleURL:    people.server.URL,
+		AuthURL:      auth.server.URL,
+		UserName:     "alice",
+		Password:     "secret",
+		Tenant:       "acme",
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestTokenIsRequestedOnceAndSent(t *testing.T) {
+	auth := newTestAuth(t)
+	people := newTestPeople(t)
+	client := newTestClient(t, auth, people)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetPerson(context.Background(), "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if auth.requests.Load() != 1 {
+		t.Errorf("auth requests = %d, want 1", auth.requests.Load())
+	}
+
+	request := http.Request{Header: auth.header}
+	user, password, _ := request.BasicAuth()
+
+	if user != "alice" || password != "secret" || auth.header.Get("X-Tenant") != "acme" {
+		t.Errorf("token requested as %q with password %q and X-Tenant %q", user, password, auth.header.Get("X-Tenant"))
+	}
+
+	for _, request := range people.received() {
+		if request.Header.Get("Authorization") != "Bearer "+auth.lastIssued() {
+			t.Errorf("Authorization = %q, want the issued token", request.Header.Get("Authorization"))
+		}
+	}
+}
+
+func TestTokenRequiresPassword(t *testing.T) {
+	auth := newTestAuth(t)
+	people := newTestPeople(t)
+	client := newTestClient(t, auth, people)
+	client.config.Password = ""
+
+	_, err := client.GetPerson(context.Background(), "1")
+	if !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("err = %v, want ErrPasswordRequired", err)
+	}
+
+	// A seeded token is used without asking the auth service
+	err = client.SetToken(testToken(time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.GetPerson(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if auth.requests.Load() != 0 {
+		t.Errorf("auth requests = %d, want 0", auth.requests.Load())
+	}
+}
+
+func TestTokenIsRefreshedBeforeExpiry(t *testing.T) {
+	auth := newTestAuth(t)
+	// Every token is within RefreshBefore of its expiry
+	auth.expiry = 0
+	client := newTestClient(t, auth, newTestPeople(t))
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetPerson(context.Background(), "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if auth.requests.Load() != 2 {
+		t.Errorf("auth requests = %d, want 2", auth.requests.Load())
+	}
+}
+
+func TestUnauthorizedRefreshesTokenOnce(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      error
+		wantRequests int
+		wantTokens   int32
+	}{
+		{name: "token revoked", statuses: []int{http.StatusUnauthorized}, wantRequests: 2, wantTokens: 2},
+		{name: "new token rejected too", statuses: []int{http.StatusUnauthorized, http.StatusUnauthorized}, wantErr: ErrUnauthorized, wantRequests: 2, wantTokens: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			auth := newTestAuth(t)
+			people := newTestPeople(t, test.statuses...)
+			client := newTestClient(t, auth, people)
+
+			_, err := client.GetPerson(context.Background(), "1")
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Errorf("err = %v, want %v", err, test.wantErr)
+			}
+
+			if len(people.received()) != test.wantRequests {
+				t.Errorf("people requests = %d, want %d", len(people.received()), test.wantRequests)
+			}
+
+			if auth.requests.Load() != test.wantTokens {
+				t.Errorf("auth requests = %d, want %d", auth.requests.Load(), test.wantTokens)
+			}
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      error
+		wantRequests int
+	}{
+		{name: "success", wantRequests: 1},
+		{name: "recovers from 5xx", statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway}, wantRequests: 3},
+		{name: "gives up after max retries", statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}, wantErr: ErrServer, wantRequests: 3},
+		{name: "4xx is not retried", statuses: []int{http.StatusBadRequest}, wantErr: ErrBadRequest, wantRequests: 1},
+		{name: "404 is not retried", statuses: []int{http.StatusNotFound}, wantErr: ErrNotFound, wantRequests: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			people := newTestPeople(t, test.statuses...)
+			client := newTestClient(t, newTestAuth(t), people)
+
+			err := client.DeletePerson(context.Background(), "1")
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Errorf("err = %v, want %v", err, test.wantErr)
+			}
+
+			if len(people.received()) != test.wantRequests {
+				t.Errorf("people requests = %d, want %d", len(people.received()), test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	people := newTestPeople(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	client := newTestClient(t, newTestAuth(t), people)
+	client.config.RetryBackoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := client.DeletePerson(ctx, "1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+
+	if len(people.received()) != 1 {
+		t.Errorf("people requests = %d, want 1", len(people.received()))
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{status: http.StatusBadRequest, want: ErrBadRequest},
+		{status: http.StatusForbidden, want: ErrForbidden},
+		{status: http.StatusNotFound, want: ErrNotFound},
+		{status: http.StatusConflict, want: ErrConflict},
+		{status: http.StatusUnprocessableEntity, want: ErrUnprocessable},
+		{status: http.StatusInternalServerError, want: ErrServer},
+		{status: http.StatusGatewayTimeout, want: ErrServer},
+	}
+
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			client := newTestClient(t, newTestAuth(t), newTestPeople(t, test.status, test.status, test.status))
+
+			err := client.UpdatePerson(context.Background(), Person{ID: "1"})
+
+			apiErr := &APIError{}
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want an *APIError", err)
+			}
+
+			if apiErr.StatusCode != test.status || !errors.Is(err, test.want) {
+				t.Errorf("err = %v, want status %d matching %v", err, test.status, test.want)
+			}
+
+			if apiErr.Message != http.StatusText(test.status) {
+				t.Errorf("Message = %q, want the response body", apiErr.Message)
+			}
+		})
+	}
+}
+
+func TestGetPersonNotFound(t *testing.T) {
+	client := newTestClient(t, newTestAuth(t), newTestPeople(t, http.StatusNotFound))
+
+	_, err := client.GetPerson(context.Background(), "1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCreatePersonRetriesWithSameIdempotencyKey(t *testing.T) {
+	people := newTestPeople(t, http.StatusBadGateway, http.StatusServiceUnavailable)
+	client := newTestClient(t, newTestAuth(t), people)
+
+	err := client.CreatePerson(context.Background(), Person{ID: "1", Name: "Ada", LastName: "Lovelace", Age: 36})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.CreatePerson(context.Background(), Person{ID: "2", Name: "Alan", LastName: "Turing", Age: 41})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := people.received()
+	if len(received) != 4 {
+		t.Fatalf("people requests = %d, want 4", len(received))
+	}
+
+	first := received[0].Header.Get("Idempotency-Key")
+	if first == "" {
+		t.Fatal("Idempotency-Key is missing")
+	}
+
+	for _, request := range received[1:3] {
+		if request.Header.Get("Idempotency-Key") != first {
+			t.Errorf("retry Idempotency-Key = %q, want %q", request.Header.Get("Idempotency-Key"), first)
+		}
+	}
+
+	if received[3].Header.Get("Idempotency-Key") == first {
+		t.Error("a new person was created with the Idempotency-Key of the previous one")
+	}
+}
+
+func TestGetPersonDecodesResponse(t *testing.T) {
+	people := newTestPeople(t)
+	people.body = `{"id":"1","name":"Ada","lastName":"Lovelace","age":36,"owner":"alice"}`
+	client := newTestClient(t, newTestAuth(t), people)
+
+	person, err := client.GetPerson(context.Background(), "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Person{ID: "1", Name: "Ada", LastName: "Lovelace", Age: 36, Owner: "alice"}
+	if got, _ := json.Marshal(person); string(got) != mustMarshal(want) {
+		t.Errorf("person = %s, want %s", got, mustMarshal(want))
+	}
+
+	if path := people.received()[0].URL.EscapedPath(); !strings.HasSuffix(path, "/person/a%2Fb") {
+		t.Errorf("path = %q, want the id escaped", path)
+	}
+}
+
+func mustMarshal(value interface{}) string {
+	bytes, _ := json.Marshal(value)
+
+	return string(bytes)
+}
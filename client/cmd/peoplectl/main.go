@@ -0,0 +1,300 @@
+// Command peoplectl manages people through the people and auth services.
+//
+// Environments are kept as profiles in ~/.config/peoplectl/config.yaml; snbx and eng are built in
+// and match the ingress hosts of the Helm values files. Tokens are cached per profile so that
+// only `peoplectl login` or an expired token reaches the auth service. The password is read from
+// PEOPLECTL_PASSWORD, or from stdin with `peoplectl login --password-stdin`, and never stored.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pavr1/people_project/client"
+)
+
+type app struct {
+	configPath  string
+	profileName string
+	output      string
+	// password is the password given to login, PEOPLECTL_PASSWORD otherwise
+	password string
+
+	file   *ProfileFile
+	client *client.Client
+}
+
+func main() {
+	err := newRootCommand(&app{}).Execute()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand(a *app) *cobra.Command {
+	root := &cobra.Command{
+		Use:          "peoplectl",
+		Short:        "Manage people through the people service",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			file, err := loadProfileFile(a.configPath)
+			if err != nil {
+				return err
+			}
+
+			a.file = file
+
+			return nil
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			return a.saveToken(cmd.Context())
+		},
+	}
+
+	root.PersistentFlags().StringVar(&a.configPath, "config", defaultConfigPath(), "profile file")
+	root.PersistentFlags().StringVarP(&a.profileName, "profile", "p", "", "profile to use, defaults to the current profile")
+	root.PersistentFlags().StringVarP(&a.output, "output", "o", "table", "output format: table, json or csv")
+
+	root.RegisterFlagCompletionFunc("profile", a.completeProfiles)
+	root.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return outputFormats, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	root.AddCommand(
+		newLoginCommand(a),
+		newListCommand(a),
+		newGetCommand(a),
+		newCreateCommand(a),
+		newUpdateCommand(a),
+		newDeleteCommand(a),
+		newImportCommand(a),
+		newExportCommand(a),
+		newSearchCommand(a),
+		newProfileCommand(a),
+	)
+
+	return root
+}
+
+// newClient builds a client for the selected profile, seeded with its cached token.
+func (a *app) newClient() (*client.Client, error) {
+	name, profile, err := a.file.profile(a.profileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile.UserName == "" {
+		return nil, fmt.Errorf("profile %q has no user, run `peoplectl login --user <name>`", name)
+	}
+
+	password := a.password
+	if password == "" {
+		password = os.Getenv("PEOPLECTL_PASSWORD")
+	}
+
+	c, err := client.NewClient(client.Config{
+		PeopleURL: profile.PeopleURL,
+		AuthURL:   profile.AuthURL,
+		UserName:  profile.UserName,
+		Password:  password,
+		Tenant:    profile.Tenant,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	token := loadToken(name)
+	if token != "" {
+		// a corrupt cache only means a new token is fetched
+		c.SetToken(token)
+	}
+
+	a.client = c
+
+	return c, nil
+}
+
+// saveToken persists the token of the client used by the command, if any.
+func (a *app) saveToken(ctx context.Context) error {
+	if a.client == nil {
+		return nil
+	}
+
+	name, _, err := a.file.profile(a.profileName)
+	if err != nil {
+		return err
+	}
+
+	token, err := a.client.Token(ctx)
+	if err != nil {
+		// the command already reported its own failure
+		return nil
+	}
+
+	return saveToken(name, token)
+}
+
+func (a *app) completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	file, err := loadProfileFile(a.configPath)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	return file.names(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func newLoginCommand(a *app) *cobra.Command {
+	userName := ""
+	passwordStdin := false
+
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Fetch and cache a token for the profile",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, profile, err := a.file.profile(a.profileName)
+			if err != nil {
+				return err
+			}
+
+			if userName != "" && userName != profile.UserName {
+				profile.UserName = userName
+				a.file.Profiles[name] = profile
+
+				err = a.file.save(a.configPath)
+				if err != nil {
+					return err
+				}
+			}
+
+			if passwordStdin {
+				password, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+				if err != nil && password == "" {
+					return fmt.Errorf("failed to read the password from stdin: %w", err)
+				}
+
+				a.password = strings.TrimRight(password, "\r\n")
+			}
+
+			// drop any cached token so login always talks to the auth service
+			os.Remove(tokenPath(name))
+
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+
+			_, err = c.Token(cmd.Context())
+			if errors.Is(err, client.ErrPasswordRequired) {
+				return errors.New("set PEOPLECTL_PASSWORD or pass --password-stdin")
+			}
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Logged in to %s as %s\n", name, profile.UserName)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&userName, "user", "u", "", "user name sent to the auth service, stored in the profile")
+	cmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the password from stdin instead of PEOPLECTL_PASSWORD")
+
+	return cmd
+}
+
+func newProfileCommand(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage environment profiles",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, name := range a.file.names() {
+				marker := " "
+				if name == a.file.Current {
+					marker = "*"
+				}
+
+				profile := a.file.Profiles[name]
+				fmt.Fprintf(cmd.OutOrStdout(), "%s %s\tpeople=%s auth=%s user=%s tenant=%s\n", marker, name, profile.PeopleURL, profile.AuthURL, profile.UserName, profile.Tenant)
+			}
+
+			return nil
+		},
+	}
+
+	use := &cobra.Command{
+		Use:               "use NAME",
+		Short:             "Make a profile the current one",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: a.completeProfiles,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, _, err := a.file.profile(args[0])
+			if err != nil {
+				return err
+			}
+
+			a.file.Current = args[0]
+
+			return a.file.save(a.configPath)
+		},
+	}
+
+	profile := Profile{}
+	set := &cobra.Command{
+		Use:   "set NAME",
+		Short: "Create or update a profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			existing := a.file.Profiles[args[0]]
+			if profile.PeopleURL != "" {
+				existing.PeopleURL = profile.PeopleURL
+			}
+
+			if profile.AuthURL != "" {
+				existing.AuthURL = profile.AuthURL
+			}
+
+			if profile.UserName != "" {
+				existing.UserName = profile.UserName
+			}
+
+			if profile.Tenant != "" {
+				existing.Tenant = profile.Tenant
+			}
+
+			if existing.PeopleURL == "" || existing.AuthURL == "" {
+				return errors.New("--people-url and --auth-url are required for a new profile")
+			}
+
+			a.file.Profiles[args[0]] = existing
+
+			// the cached token may belong to another user or tenant
+			os.Remove(tokenPath(args[0]))
+
+			return a.file.save(a.configPath)
+		},
+	}
+
+	set.Flags().StringVar(&profile.PeopleURL, "people-url", "", "base URL of the people service")
+	set.Flags().StringVar(&profile.AuthURL, "auth-url", "", "base URL of the auth service")
+	set.Flags().StringVar(&profile.UserName, "user", "", "user name sent to the auth service")
+	set.Flags().StringVar(&profile.Tenant, "tenant", "", "tenant of the user, checked by the auth service")
+
+	cmd.AddCommand(list, use, set)
+
+	return cmd
+}
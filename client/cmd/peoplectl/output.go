@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/pavr1/people_project/client"
+)
+
+var outputFormats = []string{"table", "json", "csv"}
+
+var csvHeader = []string{"id", "name", "lastName", "age"}
+
+func writePeople(w io.Writer, format string, people []client.Person) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tLAST NAME\tAGE")
+		for _, person := range people {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", person.ID, person.Name, person.LastName, person.Age)
+		}
+
+		return tw.Flush()
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(people)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, person := range people {
+			cw.Write([]string{person.ID, person.Name, person.LastName, strconv.Itoa(int(person.Age))})
+		}
+
+		cw.Flush()
+
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown output format %q, expected one of %v", format, outputFormats)
+	}
+}
+
+// readPeople parses a JSON array or a CSV file with the same columns writePeople produces.
+func readPeople(r io.Reader, format string) ([]client.Person, error) {
+	switch format {
+	case "json":
+		people := []client.Person{}
+
+		err := json.NewDecoder(r).Decode(&people)
+		if err != nil {
+			return nil, err
+		}
+
+		return people, nil
+	case "csv":
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+
+		people := []client.Person{}
+		for i, record := range records {
+			if i == 0 && record[0] == csvHeader[0] {
+				continue
+			}
+
+			if len(record) != len(csvHeader) {
+				return nil, fmt.Errorf("line %d: expected %d columns, got %d", i+1, len(csvHeader), len(record))
+			}
+
+			age, err := strconv.ParseInt(record[3], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid age %q", i+1, record[3])
+			}
+
+			people = append(people, client.Person{
+				ID:       record[0],
+				Name:     record[1],
+				LastName: record[2],
+				Age:      int32(age),
+			})
+		}
+
+		return people, nil
+	default:
+		return nil, fmt.Errorf("unknown input format %q, expected json or csv", format)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pavr1/people_project/client"
+)
+
+func newListCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List every person",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+
+			people, err := c.GetPersonList(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			return writePeople(cmd.OutOrStdout(), a.output, people)
+		},
+	}
+}
+
+func newGetCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get ID",
+		Short: "Show one person",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+
+			person, err := c.GetPerson(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+
+			return writePeople(cmd.OutOrStdout(), a.output, []client.Person{*person})
+		},
+	}
+}
+
+// addPersonFlags binds the person fields shared by create and update.
+func addPersonFlags(cmd *cobra.Command, person *client.Person) {
+	cmd.Flags().StringVar(&person.ID, "id", "", "person id")
+	cmd.Flags().StringVar(&person.Name, "name", "", "first name")
+	cmd.Flags().StringVar(&person.LastName, "last-name", "", "last name")
+	cmd.Flags().Int32Var(&person.Age, "age", 0, "age")
+
+	for _, flag := range []string{"id", "name", "last-name", "age"} {
+		cmd.MarkFlagRequired(flag)
+	}
+}
+
+func newCreateCommand(a *app) *cobra.Command {
+	person := client.Person{}
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a person",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+
+			err = c.CreatePerson(cmd.Context(), person)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Person %s created\n", person.ID)
+
+			return nil
+		},
+	}
+
+	addPersonFlags(cmd, &person)
+
+	return cmd
+}
+
+func newUpdateCommand(a *app) *cobra.Command {
+	person := client.Person{}
+
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Update a person",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+
+			err = c.UpdatePerson(cmd.Context(), person)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Person %s updated\n", person.ID)
+
+			return nil
+		},
+	}
+
+	addPersonFlags(cmd, &person)
+
+	return cmd
+}
+
+func newDeleteCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete ID",
+		Short: "Delete a person",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+
+			err = c.DeletePerson(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Person %s deleted\n", args[0])
+
+			return nil
+		},
+	}
+}
+
+func newImportCommand(a *app) *cobra.Command {
+	format := ""
+	upsert := false
+
+	cmd := &cobra.Command{
+		Use:   "import FILE",
+		Short: "Create people from a JSON or CSV file, - reads stdin",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format == "" {
+				format = strings.TrimPrefix(filepath.Ext(args[0]), ".")
+			}
+
+			var input io.Reader = cmd.InOrStdin()
+			if args[0] != "-" {
+				file, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+
+				defer file.Close()
+				input = file
+			}
+
+			people, err := readPeople(input, format)
+			if err != nil {
+				return err
+			}
+
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+
+			created, updated, failed := 0, 0, 0
+			for _, person := range people {
+				err = c.CreatePerson(cmd.Context(), person)
+				if errors.Is(err, client.ErrConflict) && upsert {
+					err = c.UpdatePerson(cmd.Context(), person)
+					if err == nil {
+						updated++
+
+						continue
+					}
+				}
+
+				if err != nil {
+					failed++
+					fmt.Fprintf(cmd.ErrOrStderr(), "Person %s: %v\n", person.ID, err)
+
+					continue
+				}
+
+				created++
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Imported %d people: %d created, %d updated, %d failed\n", len(people), created, updated, failed)
+
+			if failed > 0 {
+				return fmt.Errorf("%d people could not be imported", failed)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "", "input format, json or csv; defaults to the file extension")
+	cmd.Flags().BoolVar(&upsert, "upsert", false, "update people that already exist instead of failing")
+
+	return cmd
+}
+
+func newExportCommand(a *app) *cobra.Command {
+	path := ""
+	pageSize := int64(0)
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write every person in the output format",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+
+			people := []client.Person{}
+
+			it := c.IteratePersonList(cmd.Context(), pageSize)
+			for it.Next() {
+				people = append(people, it.Person())
+			}
+
+			err = it.Err()
+			if err != nil {
+				return err
+			}
+
+			output := cmd.OutOrStdout()
+			if path != "" {
+				file, err := os.Create(path)
+				if err != nil {
+					return err
+				}
+
+				defer file.Close()
+				output = file
+			}
+
+			return writePeople(output, a.output, people)
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "file", "f", "", "write to a file instead of stdout")
+	cmd.Flags().Int64Var(&pageSize, "page-size", 100, "people fetched per request")
+
+	return cmd
+}
+
+func newSearchCommand(a *app) *cobra.Command {
+	name := ""
+	lastName := ""
+	minAge := int32(0)
+	maxAge := int32(0)
+
+	cmd := &cobra.Command{
+		Use:   "search",
+		Short: "Find people by name, last name or age range",
+		Long:  "Find people by name, last name or age range. Names match case-insensitively on any substring.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+
+			people := []client.Person{}
+
+			it := c.IteratePersonList(cmd.Context(), 100)
+			for it.Next() {
+				person := it.Person()
+				if !containsFold(person.Name, name) || !containsFold(person.LastName, lastName) {
+					continue
+				}
+
+				if (minAge > 0 && person.Age < minAge) || (maxAge > 0 && person.Age > maxAge) {
+					continue
+				}
+
+				people = append(people, person)
+			}
+
+			err = it.Err()
+			if err != nil {
+				return err
+			}
+
+			return writePeople(cmd.OutOrStdout(), a.output, people)
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "first name contains")
+	cmd.Flags().StringVar(&lastName, "last-name", "", "last name contains")
+	cmd.Flags().Int32Var(&minAge, "min-age", 0, "minimum age")
+	cmd.Flags().Int32Var(&maxAge, "max-age", 0, "maximum age")
+
+	return cmd
+}
+
+func containsFold(value string, substr string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(substr))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile describes one environment peoplectl can talk to.
+type Profile struct {
+	PeopleURL string `yaml:"peopleUrl"`
+	AuthURL   string `yaml:"authUrl"`
+	UserName  string `yaml:"userName,omitempty"`
+	Tenant    string `yaml:"tenant,omitempty"`
+}
+
+// ProfileFile is the on-disk profile file, by default ~/.config/peoplectl/config.yaml.
+type ProfileFile struct {
+	Current  string             `yaml:"current"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// defaultProfiles match the ingress hosts in the snbx and eng Helm values files.
+func defaultProfiles() map[string]Profile {
+	return map[string]Profile{
+		"snbx": {
+			PeopleURL: "http://kubernetes.people.internal.snbx.com",
+			AuthURL:   "http://kubernetes.auth.internal.snbx.com",
+		},
+		"eng": {
+			PeopleURL: "http://kubernetes.people.internal.eng.com",
+			AuthURL:   "http://kubernetes.auth.internal.eng.com",
+		},
+	}
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+
+	return filepath.Join(dir, "peoplectl", "config.yaml")
+}
+
+func loadProfileFile(path string) (*ProfileFile, error) {
+	file := &ProfileFile{
+		Current:  "snbx",
+		Profiles: defaultProfiles(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+func (f *ProfileFile) save(path string) error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// profile returns the named profile, or the current one when name is empty.
+func (f *ProfileFile) profile(name string) (string, Profile, error) {
+	if name == "" {
+		name = f.Current
+	}
+
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return "", Profile{}, fmt.Errorf("profile %q not found, available: %s", name, strings.Join(f.names(), ", "))
+	}
+
+	return name, profile, nil
+}
+
+func (f *ProfileFile) names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// tokenPath is where the token of a profile is cached between invocations.
+func tokenPath(profile string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = "."
+	}
+
+	return filepath.Join(dir, "peoplectl", profile+".token")
+}
+
+func loadToken(profile string) string {
+	data, err := os.ReadFile(tokenPath(profile))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func saveToken(profile string, token string) error {
+	path := tokenPath(profile)
+
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(token), 0o600)
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SubjectExport is everything the service stores about one person.
+type SubjectExport struct {
+	ExportedAt time.Time              `json:"exportedAt"`
+	Tenant     string                 `json:"tenant"`
+	Record     map[string]interface{} `json:"record"`
+}
+
+// ErasureReceipt proves a person was erased; it identifies the person by SubjectHash only.
+type ErasureReceipt struct {
+	Tenant       string    `json:"tenant"`
+	Sequence     int64     `json:"sequence"`
+	SubjectHash  string    `json:"subjectHash"`
+	ErasedBy     string    `json:"erasedBy"`
+	ErasedAt     time.Time `json:"erasedAt"`
+	Records      int64     `json:"records"`
+	PreviousHash string    `json:"previousHash"`
+	Hash         string    `json:"hash"`
+	// Status is pending while a failed erasure still has to be retried, completed otherwise
+	Status string `json:"status"`
+}
+
+// ExportPerson returns everything stored about the person with the given id.
+func (c *Client) ExportPerson(ctx context.Context, id string) (*SubjectExport, error) {
+	resp, err := c.do(ctx, request{
+		method:  http.MethodGet,
+		url:     c.config.PeopleURL + "/person/export/" + url.PathEscape(id),
+		retry:   true,
+		expects: http.StatusOK,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	export := SubjectExport{}
+
+	err = json.Unmarshal(resp.body, &export)
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// ErasePerson irreversibly erases the person with the given id. It is not retried, a retry after
+// a lost response would fail with ErrNotFound and the receipt would be lost.
+func (c *Client) ErasePerson(ctx context.Context, id string) (*ErasureReceipt, error) {
+	resp, err := c.do(ctx, request{
+		method:  http.MethodDelete,
+		url:     c.config.PeopleURL + "/person/erase/" + url.PathEscape(id),
+		expects: http.StatusOK,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := ErasureReceipt{}
+
+	err = json.Unmarshal(resp.body, &receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &receipt, nil
+}
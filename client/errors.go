@@ -0,0 +1,63 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	// ErrUnprocessable is returned when an Idempotency-Key is reused with a different request
+	ErrUnprocessable = errors.New("unprocessable")
+	ErrServer        = errors.New("server error")
+	// ErrPasswordRequired is returned when a token must be requested but no password is configured
+	ErrPasswordRequired = errors.New("password required to request a token")
+)
+
+// APIError is returned for every response with an unexpected status code.
+// Use errors.Is with the Err* values to check its kind.
+type APIError struct {
+	StatusCode int
+	Message    string
+	kind       error
+}
+
+func newAPIError(statusCode int, message string) *APIError {
+	var kind error
+
+	switch {
+	case statusCode == http.StatusBadRequest:
+		kind = ErrBadRequest
+	case statusCode == http.StatusUnauthorized:
+		kind = ErrUnauthorized
+	case statusCode == http.StatusForbidden:
+		kind = ErrForbidden
+	case statusCode == http.StatusNotFound:
+		kind = ErrNotFound
+	case statusCode == http.StatusConflict:
+		kind = ErrConflict
+	case statusCode == http.StatusUnprocessableEntity:
+		kind = ErrUnprocessable
+	case statusCode >= http.StatusInternalServerError:
+		kind = ErrServer
+	}
+
+	return &APIError{
+		StatusCode: statusCode,
+		Message:    message,
+		kind:       kind,
+	}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	return e.kind != nil && e.kind == target
+}
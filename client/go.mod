@@ -0,0 +1,13 @@
+module github.com/pavr1/people_project/client
+
+go 1.22
+
+require (
+	github.com/spf13/cobra v1.8.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+)
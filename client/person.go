@@ -0,0 +1,246 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+type Person struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	LastName string `json:"lastName"`
+	Age      int32  `json:"age"`
+	// Owner, Readers and Writers are set by the service; they are ignored on create and update.
+	Owner   string   `json:"owner,omitempty"`
+	Readers []string `json:"readers,omitempty"`
+	Writers []string `json:"writers,omitempty"`
+}
+
+// Grants lists who besides the owner may read or write a person, as user:<name> or group:<name> entries.
+type Grants struct {
+	Readers []string `json:"readers"`
+	Writers []string `json:"writers"`
+}
+
+// GetPersonList returns every person.
+func (c *Client) GetPersonList(ctx context.Context) ([]Person, error) {
+	return c.getPersonPage(ctx, 0, 0)
+}
+
+// GetPerson returns the person with the given id. A missing person yields an error matching ErrNotFound.
+func (c *Client) GetPerson(ctx context.Context, id string) (*Person, error) {
+	resp, err := c.do(ctx, request{
+		method:  http.MethodGet,
+		url:     c.config.PeopleURL + "/person/" + url.PathEscape(id),
+		retry:   true,
+		expects: http.StatusOK,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	person := Person{}
+
+	err = json.Unmarshal(resp.body, &person)
+	if err != nil {
+		return nil, err
+	}
+
+	return &person, nil
+}
+
+// CreatePerson stores a new person. Every attempt carries the same Idempotency-Key, so retries
+// after a lost response replay the first outcome instead of creating the person twice.
+func (c *Client) CreatePerson(ctx context.Context, person Person) error {
+	body, err := json.Marshal(person)
+	if err != nil {
+		return err
+	}
+
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.do(ctx, request{
+		method:  http.MethodPost,
+		url:     c.config.PeopleURL + "/person/create",
+		header:  http.Header{"Idempotency-Key": []string{key}},
+		body:    body,
+		retry:   true,
+		expects: http.StatusOK,
+	})
+
+	return err
+}
+
+func (c *Client) UpdatePerson(ctx context.Context, person Person) error {
+	body, err := json.Marshal(person)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.do(ctx, request{
+		method:  http.MethodPut,
+		url:     c.config.PeopleURL + "/person/update",
+		body:    body,
+		retry:   true,
+		expects: http.StatusOK,
+	})
+
+	return err
+}
+
+func (c *Client) DeletePerson(ctx context.Context, id string) error {
+	_, err := c.do(ctx, request{
+		method:  http.MethodDelete,
+		url:     c.config.PeopleURL + "/person/delete/" + url.PathEscape(id),
+		retry:   true,
+		expects: http.StatusOK,
+	})
+
+	return err
+}
+
+// SetGrants replaces the grants of a person. Only its owner or an admin may do so.
+func (c *Client) SetGrants(ctx context.Context, id string, grants Grants) error {
+	body, err := json.Marshal(grants)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.do(ctx, request{
+		method:  http.MethodPut,
+		url:     c.config.PeopleURL + "/person/grant/" + url.PathEscape(id),
+		body:    body,
+		retry:   true,
+		expects: http.StatusOK,
+	})
+
+	return err
+}
+
+func (c *Client) getPersonPage(ctx context.Context, offset int64, limit int64) ([]Person, error) {
+	query := url.Values{}
+	if offset > 0 {
+		query.Set("offset", fmt.Sprint(offset))
+	}
+
+	if limit > 0 {
+		query.Set("limit", fmt.Sprint(limit))
+	}
+
+	path := c.config.PeopleURL + "/person/list"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp, err := c.do(ctx, request{
+		method:  http.MethodGet,
+		url:     path,
+		retry:   true,
+		expects: http.StatusOK,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	people := []Person{}
+
+	err = json.Unmarshal(resp.body, &people)
+	if err != nil {
+		return nil, err
+	}
+
+	return people, nil
+}
+
+// PersonIterator walks the person list one page at a time.
+//
+//	it := c.IteratePersonList(ctx, 100)
+//	for it.Next() {
+//		person := it.Person()
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type PersonIterator struct {
+	client   *Client
+	ctx      context.Context
+	pageSize int64
+	offset   int64
+	page     []Person
+	index    int
+	done     bool
+	err      error
+}
+
+// IteratePersonList returns an iterator that fetches pageSize people per request.
+func (c *Client) IteratePersonList(ctx context.Context, pageSize int64) *PersonIterator {
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+
+	return &PersonIterator{
+		client:   c,
+		ctx:      ctx,
+		pageSize: pageSize,
+		index:    -1,
+	}
+}
+
+// Next advances to the next person, fetching a new page when needed.
+func (it *PersonIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.index++
+	if it.index < len(it.page) {
+		return true
+	}
+
+	if it.done {
+		return false
+	}
+
+	page, err := it.client.getPersonPage(it.ctx, it.offset, it.pageSize)
+	if err != nil {
+		it.err = err
+
+		return false
+	}
+
+	it.page = page
+	it.index = 0
+	it.offset += int64(len(page))
+	it.done = int64(len(page)) < it.pageSize
+
+	return len(page) > 0
+}
+
+// Person returns the current person. It is only valid after Next returned true.
+func (it *PersonIterator) Person() Person {
+	return it.page[it.index]
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *PersonIterator) Err() error {
+	return it.err
+}
+
+func newIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
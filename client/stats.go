@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// StatsOptions are the optional parameters of GetPersonStats; zero values use the service defaults.
+type StatsOptions struct {
+	// AgeBuckets are ascending age histogram boundaries
+	AgeBuckets []int32
+	// Top is how many of the most common last names to return
+	Top int64
+	// GroupBy is an indexed field to break the totals down by
+	GroupBy string
+}
+
+type PersonStats struct {
+	Total        int64        `json:"total"`
+	Age          AgeStats     `json:"age"`
+	TopLastNames []NameCount  `json:"topLastNames"`
+	Groups       []GroupStats `json:"groups,omitempty"`
+}
+
+type AgeStats struct {
+	Min        int32       `json:"min"`
+	Max        int32       `json:"max"`
+	Mean       float64     `json:"mean"`
+	Median     float64     `json:"median"`
+	Histogram  []AgeBucket `json:"histogram"`
+	OutOfRange int64       `json:"outOfRange"`
+}
+
+// AgeBucket counts the people with From <= age < To.
+type AgeBucket struct {
+	From  int32 `json:"from"`
+	To    int32 `json:"to"`
+	Count int64 `json:"count"`
+}
+
+type NameCount struct {
+	LastName string `json:"lastName"`
+	Count    int64  `json:"count"`
+}
+
+type GroupStats struct {
+	Key     interface{} `json:"key"`
+	Total   int64       `json:"total"`
+	MinAge  int32       `json:"minAge"`
+	MaxAge  int32       `json:"maxAge"`
+	MeanAge float64     `json:"meanAge"`
+}
+
+// GetPersonStats aggregates the people the caller may read.
+func (c *Client) GetPersonStats(ctx context.Context, options StatsOptions) (*PersonStats, error) {
+	query := url.Values{}
+	if len(options.AgeBuckets) > 0 {
+		buckets := make([]string, 0, len(options.AgeBuckets))
+		for _, boundary := range options.AgeBuckets {
+			buckets = append(buckets, fmt.Sprint(boundary))
+		}
+
+		query.Set("buckets", strings.Join(buckets, ","))
+	}
+
+	if options.Top > 0 {
+		query.Set("top", fmt.Sprint(options.Top))
+	}
+
+	if options.GroupBy != "" {
+		query.Set("groupBy", options.GroupBy)
+	}
+
+	path := c.config.PeopleURL + "/person/stats"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp, err := c.do(ctx, request{
+		method:  http.MethodGet,
+		url:     path,
+		retry:   true,
+		expects: http.StatusOK,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := PersonStats{}
+
+	err = json.Unmarshal(resp.body, &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenSource caches the token issued by the auth service.
+type tokenSource struct {
+	client *Client
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newTokenSource(client *Client) *tokenSource {
+	return &tokenSource{client: client}
+}
+
+func (t *tokenSource) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && (t.expiry.IsZero() || time.Until(t.expiry) > t.client.config.RefreshBefore) {
+		return t.token, nil
+	}
+
+	if t.client.config.Password == "" {
+		return "", ErrPasswordRequired
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte(t.client.config.UserName + ":" + t.client.config.Password))
+
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+credentials)
+	if t.client.config.Tenant != "" {
+		header.Set("X-Tenant", t.client.config.Tenant)
+	}
+
+	resp, err := t.client.do(ctx, request{
+		method:  http.MethodPost,
+		url:     t.client.config.AuthURL + "/auth/token",
+		header:  header,
+		retry:   true,
+		noAuth:  true,
+		expects: http.StatusOK,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(resp.body))
+
+	expiry, err := tokenExpiry(token)
+	if err != nil {
+		return "", err
+	}
+
+	t.token = token
+	t.expiry = expiry
+
+	return t.token, nil
+}
+
+func (t *tokenSource) set(token string, expiry time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.token = token
+	t.expiry = expiry
+}
+
+func (t *tokenSource) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.token = ""
+	t.expiry = time.Time{}
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it; verification is the
+// server's job. A token without exp yields the zero time.
+func tokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("auth service returned a malformed token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if claims.Exp == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Unix(claims.Exp, 0), nil
+}
@@ -2,150 +2,327 @@ package config
 
 import (
 	"errors"
-	"os"
+	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/pavr1/people_project/shared/admin"
+	"github.com/pavr1/people_project/shared/configloader"
+	"github.com/pavr1/people_project/shared/httpclient"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/ratelimit"
+	"github.com/pavr1/people_project/shared/secrets"
+	"github.com/pavr1/people_project/shared/tracing"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// AuthModeLocal verifies tokens with the keys of the auth service, fetched periodically
+	AuthModeLocal = "local"
+	// AuthModeRemote asks the auth service to verify every token
+	AuthModeRemote = "remote"
+)
+
+const (
+	// TenantModeField stores every tenant in one collection, scoped by a tenant field
+	TenantModeField = "field"
+	// TenantModeCollection stores each tenant in its own <collection>_<tenant> collection
+	TenantModeCollection = "collection"
+)
+
+const (
+	// DropPolicyNewest discards the observation that does not fit a full push queue
+	DropPolicyNewest = "newest"
+	// DropPolicyOldest makes room by discarding the oldest queued observation
+	DropPolicyOldest = "oldest"
+)
+
+// Config is loaded by configloader, see there for how settings map to files, environment
+// variables and flags.
 type Config struct {
+	Log struct {
+		// Level is a logrus level such as info or debug
+		Level string `mapstructure:"level" default:"debug" reload:"true"`
+		// Format is text or json
+		Format string `mapstructure:"format" default:"text" reload:"true"`
+		// ReportCaller adds the function and file of the code logging to each entry
+		ReportCaller bool `mapstructure:"report_caller" default:"true" reload:"true"`
+	} `mapstructure:"log"`
 	Server struct {
-		Port int `mapstructure:"port"`
+		Port int `mapstructure:"port" validate:"required,positive"`
 	} `mapstructure:"server"`
 	Auth struct {
-		Path string `mapstructure:"path"`
-		Host string `mapstructure:"host"`
+		// Path is the token endpoint of the auth service, used for remote verification
+		Path string `mapstructure:"path" validate:"required" reload:"true"`
+		Host string `mapstructure:"host" validate:"required" reload:"true"`
+		// Mode is AuthModeLocal or AuthModeRemote
+		Mode string `mapstructure:"mode" default:"local" reload:"true"`
+		// JWKSUrl serves the token verification keys, /auth/jwks next to Path when empty
+		JWKSUrl string `mapstructure:"jwks_url" env:"AUTH_JWKS_URL" reload:"true"`
+		// RefreshInterval is how often the verification keys are fetched again
+		RefreshInterval time.Duration `mapstructure:"refresh_interval" default:"5m" validate:"positive" reload:"true"`
+		// Issuer is the iss claim required of tokens, any issuer is accepted when empty
+		Issuer string `mapstructure:"issuer" default:"auth" reload:"true"`
 	} `mapstructure:"auth"`
 	MongoDB struct {
-		Uri        string `mapstructure:"uri"`
-		Database   string `mapstructure:"database"`
-		Collection string `mapstructure:"collection"`
-		//pvillalobos add this to a secret later
-		Username string `mapstructure:"username"`
-		Password string `mapstructure:"password"`
-		RolName  string `mapstructure:"role"`
+		Uri        string `mapstructure:"uri" validate:"required" secret:"url"`
+		Database   string `mapstructure:"database" validate:"required"`
+		Collection string `mapstructure:"collection" validate:"required"`
+		// Username and Password are optional, credentials in Uri are used without them
+		Username string         `mapstructure:"username"`
+		Password secrets.Secret `mapstructure:"password"`
+		// AuthSource is the database holding the user, the driver default applies when empty
+		AuthSource string `mapstructure:"auth_source"`
+		// AuthMechanism such as SCRAM-SHA-256 or MONGODB-X509, negotiated when empty
+		AuthMechanism string `mapstructure:"auth_mechanism"`
+		// Role must be granted to the connected user on Database or startup fails; readWrite is all
+		// the service needs, roles on other databases such as root do not count. Empty skips the
+		// check, e.g. for a local MongoDB without authentication
+		Role string `mapstructure:"role" default:"readWrite"`
+		// TenantMode is TenantModeField or TenantModeCollection
+		TenantMode string `mapstructure:"tenant_mode" default:"field"`
+		TLS        struct {
+			Enabled bool `mapstructure:"enabled" env:"MONGODB_TLS"`
+			// CAFile is a PEM bundle of the CAs trusted to sign the server certificate
+			CAFile string `mapstructure:"ca_file"`
+			// CertificateKeyFile is a PEM file with the client certificate and key, for X.509 auth
+			CertificateKeyFile string `mapstructure:"certificate_key_file"`
+			// Insecure skips verifying the server certificate, for development only
+			Insecure bool `mapstructure:"insecure"`
+		} `mapstructure:"tls"`
+		Pool struct {
+			MaxSize uint64 `mapstructure:"max_size" env:"MONGODB_MAX_POOL_SIZE" default:"100"`
+			MinSize uint64 `mapstructure:"min_size" env:"MONGODB_MIN_POOL_SIZE" default:"0"`
+			// MaxIdleTime closes connections idle for longer, zero keeps them
+			MaxIdleTime time.Duration `mapstructure:"max_idle_time" env:"MONGODB_MAX_CONN_IDLE_TIME" default:"0s"`
+		} `mapstructure:"pool"`
+		Connect struct {
+			// Attempts is how many times startup tries to reach MongoDB before giving up
+			Attempts int `mapstructure:"attempts" default:"10" validate:"positive"`
+			// Backoff is the wait after the first failed attempt, it doubles up to MaxBackoff
+			Backoff    time.Duration `mapstructure:"backoff" default:"500ms" validate:"positive"`
+			MaxBackoff time.Duration `mapstructure:"max_backoff" default:"30s" validate:"positive"`
+			// Timeout bounds a single attempt
+			Timeout time.Duration `mapstructure:"timeout" default:"10s" validate:"positive"`
+		} `mapstructure:"connect"`
 	} `mapstructure:"mongodb"`
+	OpenApi struct {
+		Strict bool `mapstructure:"strict"`
+	} `mapstructure:"openapi"`
+	RateLimit struct {
+		// Default is the rate:burst limit of routes without their own limit
+		Default string `mapstructure:"default" default:"10:20" reload:"true"`
+		// Routes are comma separated route=rate:burst limits
+		Routes string `mapstructure:"routes" reload:"true"`
+		// TrustedProxies are comma separated CIDRs of the proxies whose X-Forwarded-For is believed
+		TrustedProxies string `mapstructure:"trusted_proxies"`
+		// MaxKeys caps the buckets kept in memory, the least recently used one is evicted first
+		MaxKeys int `mapstructure:"max_keys" default:"100000" validate:"positive"`
+	} `mapstructure:"rate_limit"`
+	Idempotency struct {
+		// Collection stores the Idempotency-Key records of every tenant
+		Collection string `mapstructure:"collection" default:"idempotency_keys"`
+		// Window is how long a key is remembered and its response replayed
+		Window time.Duration `mapstructure:"window" default:"24h" validate:"positive"`
+		// Lease is how long a request may hold a key before a retry may take it over, it must
+		// outlast the handling of a request
+		Lease time.Duration `mapstructure:"lease" default:"1m" validate:"positive"`
+	} `mapstructure:"idempotency"`
+	Encryption struct {
+		// Fields are the person fields encrypted at rest
+		Fields []string `mapstructure:"fields"`
+		// KeyringFile is a JSON keyring of master keys, it takes precedence over Keyring
+		KeyringFile string `mapstructure:"keyring_file"`
+		// Keyring is the JSON keyring itself
+		Keyring secrets.Secret `mapstructure:"keyring"`
+	} `mapstructure:"encryption"`
+	Erasure struct {
+		// Collection stores the erasure receipts of every tenant
+		Collection string `mapstructure:"collection" default:"erasure_receipts"`
+		// Key keys the HMACs of the erased ids and of the receipt chain, changing it invalidates
+		// the chain
+		Key secrets.Secret `mapstructure:"key" validate:"required"`
+	} `mapstructure:"erasure"`
+	Stats struct {
+		// AgeBuckets are the default age histogram boundaries of /person/stats
+		AgeBuckets []int32 `mapstructure:"age_buckets" default:"0,18,30,45,65,150"`
+		// Top is the default number of most common last names of /person/stats
+		Top int64 `mapstructure:"top" default:"10" validate:"positive"`
+	} `mapstructure:"stats"`
+	Timeouts struct {
+		// Read bounds a single MongoDB query
+		Read time.Duration `mapstructure:"read" env:"MONGODB_READ_TIMEOUT" default:"5s" validate:"positive"`
+		// Write bounds a single MongoDB insert, update or delete
+		Write time.Duration `mapstructure:"write" env:"MONGODB_WRITE_TIMEOUT" default:"5s" validate:"positive"`
+		// Aggregate bounds the MongoDB aggregations behind /person/stats
+		Aggregate time.Duration `mapstructure:"aggregate" env:"MONGODB_AGGREGATE_TIMEOUT" default:"30s" validate:"positive"`
+		// Auth bounds each attempt of a call to the auth service
+		Auth time.Duration `mapstructure:"auth" env:"AUTH_TIMEOUT" default:"5s" validate:"positive"`
+		// Metrics bounds each attempt of a call to the metrics service
+		Metrics time.Duration `mapstructure:"metrics" env:"METRICS_TIMEOUT" default:"2s" validate:"positive"`
+	} `mapstructure:"timeouts"`
+	Outbound struct {
+		// Auth is the retry and circuit breaker policy of the calls to the auth service
+		Auth httpclient.Policy `mapstructure:"auth"`
+		// Metrics is the policy of the calls to the metrics service
+		Metrics httpclient.Policy `mapstructure:"metrics"`
+	} `mapstructure:"outbound"`
+	Metrics struct {
+		// Push sends request metrics to Url as well, they are always served at /metrics
+		Push bool `mapstructure:"push"`
+		// Url is where request metrics are pushed, its /readyz is checked for readiness when pushing
+		Url string `mapstructure:"url" default:"http://prometheus:9000/prometheus/log"`
+		// Token is the ingest token of the metrics service, sent as a bearer token with each batch
+		Token secrets.Secret `mapstructure:"token"`
+		// QueueSize bounds the observations waiting to be pushed
+		QueueSize int `mapstructure:"queue_size" default:"10000" validate:"positive"`
+		// BatchSize is the most observations sent in one request
+		BatchSize int `mapstructure:"batch_size" default:"500" validate:"positive"`
+		// FlushInterval is how often the queued observations are sent
+		FlushInterval time.Duration `mapstructure:"flush_interval" default:"5s" validate:"positive"`
+		// DropPolicy is DropPolicyNewest or DropPolicyOldest, which observations a full queue discards
+		DropPolicy string `mapstructure:"drop_policy" default:"newest"`
+	} `mapstructure:"metrics"`
+	Shutdown struct {
+		// Delay keeps serving while readiness fails before draining
+		Delay time.Duration `mapstructure:"delay" default:"5s"`
+		// Timeout bounds how long requests in flight are waited for
+		Timeout time.Duration `mapstructure:"timeout" default:"20s" validate:"positive"`
+	} `mapstructure:"shutdown"`
+	Readiness struct {
+		// CacheTTL is how long a dependency check result is reused
+		CacheTTL time.Duration `mapstructure:"cache_ttl" default:"5s"`
+		// Timeout bounds a single dependency check
+		Timeout time.Duration `mapstructure:"timeout" default:"2s" validate:"positive"`
+	} `mapstructure:"readiness"`
+	Policy struct {
+		// File is an optional JSON access policy, the embedded default policy is used when empty
+		File string `mapstructure:"file"`
+	} `mapstructure:"policy"`
+	Tracing tracing.Config `mapstructure:"tracing"`
+	Admin   admin.Config   `mapstructure:"admin"`
 }
 
-func NewConfig() (*Config, error) {
-	port := os.Getenv("SERVER_PORT")
-	if port == "" {
-		log.Error("SERVER_PORT is not set")
-		return nil, errors.New("SERVER_PORT is not set")
-	}
+// Options are the loader options NewConfig(args) uses, for reloading the same configuration.
+func Options(args []string) configloader.Options {
+	return configloader.Options{Name: "people", Args: args}
+}
+
+// NewConfig loads the configuration from the defaults, the optional config file, the environment
+// and args, the command line flags.
+func NewConfig(args []string) (*Config, error) {
+	var config = Config{}
 
-	portInt, err := strconv.Atoi(port)
+	err := configloader.Load(&config, Options(args))
 	if err != nil {
-		log.WithField("error", err).Error("Failed to convert port to int")
 		return nil, err
 	}
 
-	authPath := os.Getenv("AUTH_PATH")
-	if authPath == "" {
-		log.Error("AUTH_PATH is not set")
-		return nil, errors.New("AUTH_PATH is not set")
+	log.WithField("config", configloader.Summary(config)).Info("Loaded configuration file")
+
+	return &config, nil
+}
+
+// Validate checks the rules spanning several settings.
+func (c *Config) Validate() []error {
+	problems := []error{}
+
+	if c.Auth.Mode != AuthModeLocal && c.Auth.Mode != AuthModeRemote {
+		problems = append(problems, errors.New("auth.mode (AUTH_MODE) must be local or remote"))
 	}
 
-	authHost := os.Getenv("AUTH_HOST")
-	if authHost == "" {
-		log.Error("AUTH_HOST is not set")
-		return nil, errors.New("AUTH_HOST is not set")
+	if c.Metrics.DropPolicy != DropPolicyNewest && c.Metrics.DropPolicy != DropPolicyOldest {
+		problems = append(problems, errors.New("metrics.drop_policy (METRICS_DROP_POLICY) must be newest or oldest"))
 	}
 
-	mongodb_uri := os.Getenv("MONGODB_URI")
-	if mongodb_uri == "" {
-		log.Error("MONGODB_URI is not set")
-		return nil, errors.New("MONGODB_URI is not set")
+	if c.Metrics.Push && c.Metrics.Token.IsZero() {
+		problems = append(problems, errors.New("metrics.token (METRICS_TOKEN) is required with metrics.push"))
 	}
 
-	mongodb_database := os.Getenv("MONGODB_DATABASE")
-	if mongodb_database == "" {
-		log.Error("MONGODB_DATABASE is not set")
-		return nil, errors.New("MONGODB_DATABASE is not set")
+	if c.MongoDB.TenantMode != TenantModeField && c.MongoDB.TenantMode != TenantModeCollection {
+		problems = append(problems, errors.New("mongodb.tenant_mode (MONGODB_TENANT_MODE) must be field or collection"))
 	}
 
-	mongodb_collection := os.Getenv("MONGODB_COLLECTION")
-	if mongodb_collection == "" {
-		log.Error("MONGODB_COLLECTION is not set")
-		return nil, errors.New("MONGODB_COLLECTION is not set")
+	if c.MongoDB.Username != "" && c.MongoDB.Password.IsZero() && c.MongoDB.AuthMechanism != "MONGODB-X509" {
+		problems = append(problems, errors.New("mongodb.password (MONGODB_PASSWORD) is required with mongodb.username"))
 	}
 
-	mongodb_username := os.Getenv("MONGODB_USERNAME")
-	if mongodb_username == "" {
-		log.Error("MONGODB_USERNAME is not set")
-		return nil, errors.New("MONGODB_USERNAME is not set")
+	if c.MongoDB.Pool.MaxSize != 0 && c.MongoDB.Pool.MinSize > c.MongoDB.Pool.MaxSize {
+		problems = append(problems, errors.New("mongodb.pool.min_size (MONGODB_MIN_POOL_SIZE) must not exceed mongodb.pool.max_size"))
 	}
 
-	mongodb_password := os.Getenv("MONGODB_PASSWORD")
-	if mongodb_password == "" {
-		log.Error("MONGODB_PASSWORD is not set")
-		return nil, errors.New("MONGODB_PASSWORD is not set")
+	err := ValidateAgeBuckets(c.Stats.AgeBuckets)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("stats.age_buckets (STATS_AGE_BUCKETS): %w", err))
 	}
 
-	mongodb_role := os.Getenv("MONGODB_ROLE")
-	if mongodb_role == "" {
-		log.Error("MONGODB_ROLE is not set")
-		return nil, errors.New("MONGODB_ROLE is not set")
+	err = c.Tracing.Validate()
+	if err != nil {
+		problems = append(problems, fmt.Errorf("tracing (TRACING_EXPORTER, TRACING_SAMPLE_RATIO): %w", err))
 	}
 
-	var config = Config{}
-	config.Server.Port = portInt
-	config.Auth.Path = authPath
-	config.Auth.Host = authHost
-	config.MongoDB.Uri = mongodb_uri
-	config.MongoDB.Database = mongodb_database
-	config.MongoDB.Collection = mongodb_collection
-	config.MongoDB.Username = mongodb_username
-	config.MongoDB.Password = mongodb_password
-	config.MongoDB.RolName = mongodb_role
-
-	log.WithField("config", config).Info("Loaded configuration file")
+	err = c.Admin.Validate()
+	if err != nil {
+		problems = append(problems, fmt.Errorf("admin (ADMIN_PORT, ADMIN_TOKEN): %w", err))
+	}
 
-	return &config, nil
-}
+	_, err = log.ParseLevel(c.Log.Level)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("log.level (LOG_LEVEL): %w", err))
+	}
+
+	err = logging.ValidateFormat(c.Log.Format)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("log.format (LOG_FORMAT): %w", err))
+	}
 
-// Unmarshal the configuration into a struct
-// var config Config
-// err = viper.Unmarshal(&config)
-// if err != nil {
-// 	log.WithField("error", err).Error("Failed to unmarshal configuration file")
-// 	return nil, err
-// }
+	_, err = ratelimit.ParseOptions(c.RateLimit.Default, c.RateLimit.Routes)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("rate_limit (RATE_LIMIT_DEFAULT, RATE_LIMIT_ROUTES): %w", err))
+	}
 
-// log.WithField("config", config).Info("Loaded configuration file")
+	_, err = ratelimit.ParseCIDRs(c.RateLimit.TrustedProxies)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("rate_limit.trusted_proxies (RATE_LIMIT_TRUSTED_PROXIES): %w", err))
+	}
 
-// if config.Server.Port == 0 {
-// 	return nil, errors.New("server.port is required")
-// }
+	return problems
+}
 
-// if config.Auth.Path == "" {
-// 	return nil, errors.New("auth.path is required")
-// }
+// ParseAgeBuckets parses comma separated ascending histogram boundaries, e.g. "0,18,65,150".
+func ParseAgeBuckets(value string) ([]int32, error) {
+	parts := strings.Split(value, ",")
 
-// if config.Auth.Host == "" {
-// 	return nil, errors.New("auth.host is required")
-// }
+	buckets := make([]int32, 0, len(parts))
+	for _, part := range parts {
+		boundary, err := strconv.ParseInt(strings.TrimSpace(part), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("age bucket boundary %q is not an integer", part)
+		}
 
-// if config.MongoDB.Uri == "" {
-// 	return nil, errors.New("mongodb.uri is required")
-// }
+		buckets = append(buckets, int32(boundary))
+	}
 
-// if config.MongoDB.Database == "" {
-// 	return nil, errors.New("mongodb.database is required")
-// }
+	err := ValidateAgeBuckets(buckets)
+	if err != nil {
+		return nil, err
+	}
 
-// if config.MongoDB.Collection == "" {
-// 	return nil, errors.New("mongodb.collection is required")
-// }
+	return buckets, nil
+}
 
-// if config.MongoDB.Username == "" {
-// 	return nil, errors.New("mongodb.username is required")
-// }
+// ValidateAgeBuckets checks that there are at least two ascending boundaries.
+func ValidateAgeBuckets(buckets []int32) error {
+	if len(buckets) < 2 {
+		return fmt.Errorf("age buckets %v need at least two boundaries", buckets)
+	}
 
-// if config.MongoDB.Password == "" {
-// 	return nil, errors.New("mongodb.password is required")
-// }
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("age buckets %v must be ascending", buckets)
+		}
+	}
 
-// if config.MongoDB.RolName == "" {
-// 	return nil, errors.New("mongodb.role is required")
-// }
+	return nil
+}
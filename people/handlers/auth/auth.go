@@ -1,51 +1,289 @@
 package auth
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sync/atomic"
+	"time"
 
-	"github.com/pavr1/people_project/people/config"
+	"github.com/golang-jwt/jwt/v5"
+	_config "github.com/pavr1/people_project/people/config"
+	"github.com/pavr1/people_project/people/models"
+	"github.com/pavr1/people_project/shared/health"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/tracing"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/pavr1/people_project/people/handlers/auth")
+
+// tenantPattern matches the tenant names the auth service issues.
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Claims are the token claims the people service relies on.
+type Claims struct {
+	UserName string   `json:"username"`
+	Tenant   string   `json:"tenant"`
+	Roles    []string `json:"roles"`
+	Groups   []string `json:"groups"`
+}
+
+// Principal returns the caller the repository scopes its queries to.
+func (c *Claims) Principal() *models.Principal {
+	isAdmin := false
+	for _, role := range c.Roles {
+		if role == "admin" {
+			isAdmin = true
+		}
+	}
+
+	return &models.Principal{
+		Tenant:   c.Tenant,
+		UserName: c.UserName,
+		Groups:   c.Groups,
+		IsAdmin:  isAdmin,
+	}
+}
+
+// ErrInvalidToken is returned for tokens that are malformed, expired or not issued by the auth
+// service.
+var ErrInvalidToken = errors.New("invalid token")
+
+// tokenClaims are the claims of a token verified locally.
+type tokenClaims struct {
+	Claims
+	jwt.RegisteredClaims
+}
+
 type Auth struct {
-	log    *log.Logger
-	config *config.Config
+	log *log.Logger
+	// config is replaced by SetConfig when the auth endpoint is reloaded
+	config atomic.Pointer[_config.Config]
+	client *http.Client
+	keys   *keySet
+	// intervalChanged wakes Run when a reload changes auth.refresh_interval
+	intervalChanged chan struct{}
+}
+
+// NewAuth calls the auth service with client, which applies the auth timeout and policy.
+func NewAuth(log *log.Logger, config *_config.Config, client *http.Client) *Auth {
+	auth := &Auth{
+		log:             log,
+		client:          client,
+		keys:            newKeySet(log, client),
+		intervalChanged: make(chan struct{}, 1),
+	}
+
+	auth.config.Store(config)
+
+	return auth
+}
+
+// SetConfig makes later validations use the auth endpoint of config and Run refresh the keys at
+// its interval.
+func (a *Auth) SetConfig(config *_config.Config) {
+	previous := a.config.Swap(config)
+	if previous.Auth.RefreshInterval == config.Auth.RefreshInterval {
+		return
+	}
+
+	select {
+	case a.intervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Run fetches the verification keys every auth.refresh_interval in local mode, so key changes
+// are picked up before tokens signed with the new key arrive, until ctx is done.
+func (a *Auth) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.config.Load().Auth.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		config := a.config.Load()
+		if config.Auth.Mode == _config.AuthModeLocal {
+			url, err := jwksUrl(config)
+			if err == nil {
+				err = a.keys.refresh(ctx, url, config.Auth.Host)
+			}
+
+			if err != nil {
+				a.log.WithError(err).Warn("Failed to refresh token verification keys, keeping the cached ones")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-a.intervalChanged:
+			// The keys are fetched right away and then at the new interval
+			ticker.Reset(a.config.Load().Auth.RefreshInterval)
+		}
+	}
+}
+
+// Ready tells whether tokens can be verified: in local mode the verification keys must be
+// cached, in remote mode the auth service must be ready.
+func (a *Auth) Ready(ctx context.Context) error {
+	config := a.config.Load()
+
+	if config.Auth.Mode == _config.AuthModeRemote {
+		readyUrl, err := health.ReadyURL(config.Auth.Path)
+		if err != nil {
+			return err
+		}
+
+		return health.HTTPCheck(a.client, readyUrl)(ctx)
+	}
+
+	url, err := jwksUrl(config)
+	if err != nil {
+		return err
+	}
+
+	return a.keys.ready(ctx, url, config.Auth.Host)
+}
+
+// IsValidToken verifies the token and returns its claims. Tokens that fail verification give
+// ErrInvalidToken, other errors mean the token could not be checked.
+func (a *Auth) IsValidToken(ctx context.Context, token string) (*Claims, error) {
+	config := a.config.Load()
+
+	ctx, span := tracer.Start(ctx, "Auth.IsValidToken", trace.WithAttributes(attribute.String("auth.mode", config.Auth.Mode)))
+	defer span.End()
+
+	var claims *Claims
+	var err error
+	if config.Auth.Mode == _config.AuthModeRemote {
+		claims, err = a.introspect(ctx, config, token)
+	} else {
+		claims, err = a.verify(ctx, config, token)
+	}
+
+	// A rejected token is the caller's failure, not the span's
+	if errors.Is(err, ErrInvalidToken) {
+		span.SetAttributes(attribute.Bool("auth.token_valid", false))
+
+		return nil, err
+	}
+
+	return claims, tracing.RecordError(span, err)
 }
 
-func NewAuth(log *log.Logger, config *config.Config) *Auth {
-	return &Auth{
-		log:    log,
-		config: config,
+// verify checks the signature and claims of the token with the cached keys of the auth service.
+func (a *Auth) verify(ctx context.Context, config *_config.Config, token string) (*Claims, error) {
+	url, err := jwksUrl(config)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+
+	if config.Auth.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Auth.Issuer))
+	}
+
+	claims := &tokenClaims{}
+
+	_, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		return a.keys.key(ctx, url, config.Auth.Host, kid)
+	}, options...)
+	if errors.Is(err, ErrKeysUnavailable) {
+		return nil, err
 	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return validateClaims(&claims.Claims)
 }
 
-func (a *Auth) IsValidToken(token string) (int, string, error) {
-	req, err := http.NewRequest(http.MethodGet, a.config.Auth.Path, nil)
+// introspect asks the auth service to verify the token, giving up after the configured auth
+// timeout or when ctx is done.
+func (a *Auth) introspect(ctx context.Context, config *_config.Config, token string) (*Claims, error) {
+	logger := logging.FromContext(ctx, a.log)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.Auth.Path, nil)
 	if err != nil {
-		a.log.WithError(err).Error("Failed to create request")
+		logger.WithError(err).Error("Failed to create request")
 
-		return -1, "", err
+		return nil, err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Host", a.config.Auth.Host)
+	req.Host = config.Auth.Host
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
-		a.log.WithField("Path", a.config.Auth.Path).WithError(err).Error("Failed to send request")
+		logger.WithField("url", config.Auth.Path).WithError(err).Error("Failed to send request")
 
-		return -1, "", err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		a.log.WithError(err).Error("Failed to read response")
+		logger.WithError(err).Error("Failed to read response")
 
-		return -1, "", err
+		return nil, err
 	}
 
-	return resp.StatusCode, string(body), nil
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, body)
+	}
+
+	return ParseClaims(string(body))
+}
+
+// ParseClaims reads the verified claims the auth service returns for a valid token.
+func ParseClaims(body string) (*Claims, error) {
+	claims := Claims{}
+
+	err := json.Unmarshal([]byte(body), &claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return validateClaims(&claims)
+}
+
+func validateClaims(claims *Claims) (*Claims, error) {
+	if !tenantPattern.MatchString(claims.Tenant) {
+		return nil, fmt.Errorf("%w: token has no valid tenant", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// jwksUrl returns auth.jwks_url, or /auth/jwks on the host of auth.path.
+func jwksUrl(config *_config.Config) (string, error) {
+	if config.Auth.JWKSUrl != "" {
+		return config.Auth.JWKSUrl, nil
+	}
+
+	parsed, err := url.Parse(config.Auth.Path)
+	if err != nil {
+		return "", err
+	}
+
+	parsed.Path, parsed.RawQuery = "/auth/jwks", ""
+
+	return parsed.String(), nil
 }
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pavr1/people_project/shared/logging"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// minRefetch limits how often tokens with an unknown key ID make the keys be fetched again.
+const minRefetch = 10 * time.Second
+
+// ErrKeysUnavailable is returned while the verification keys cannot be fetched.
+var ErrKeysUnavailable = errors.New("token verification keys unavailable")
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+}
+
+// keySet caches the Ed25519 keys the auth service serves at its JWKS URL. Keys are fetched
+// without holding mu, concurrent fetches of the same URL share one request.
+type keySet struct {
+	log    *log.Logger
+	client *http.Client
+	group  singleflight.Group
+
+	mu      sync.RWMutex
+	url     string
+	keys    map[string]ed25519.PublicKey
+	fetched time.Time
+}
+
+func newKeySet(log *log.Logger, client *http.Client) *keySet {
+	return &keySet{
+		log:    log,
+		client: client,
+		keys:   map[string]ed25519.PublicKey{},
+	}
+}
+
+// key returns the key with ID kid served at url, fetching the keys when it is not cached.
+func (k *keySet) key(ctx context.Context, url string, host string, kid string) (ed25519.PublicKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	cached := k.url == url
+	k.mu.RUnlock()
+
+	if ok && cached {
+		return key, nil
+	}
+
+	k.mu.Lock()
+	k.use(url)
+	key, ok = k.keys[kid]
+	due := !ok && time.Since(k.fetched) >= minRefetch
+	k.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if due {
+		err := k.refresh(ctx, url, host)
+		if err != nil {
+			return nil, err
+		}
+
+		k.mu.RLock()
+		key, ok = k.keys[kid]
+		k.mu.RUnlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	return key, nil
+}
+
+// refresh fetches the keys served at url. The cached keys are kept when that fails.
+func (k *keySet) refresh(ctx context.Context, url string, host string) error {
+	// the fetch is shared, so it must not end with the ctx of whichever caller started it
+	result := k.group.DoChan(url, func() (interface{}, error) {
+		return nil, k.fetch(context.WithoutCancel(ctx), url, host)
+	})
+
+	select {
+	case res := <-result:
+		return res.Err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrKeysUnavailable, ctx.Err())
+	}
+}
+
+// ready tells whether keys are cached, fetching them if not.
+func (k *keySet) ready(ctx context.Context, url string, host string) error {
+	k.mu.RLock()
+	loaded := k.url == url && len(k.keys) > 0
+	k.mu.RUnlock()
+
+	if loaded {
+		return nil
+	}
+
+	return k.refresh(ctx, url, host)
+}
+
+// use drops the cached keys when they were served at another URL, k.mu must be held.
+func (k *keySet) use(url string) {
+	if k.url != url {
+		k.url, k.keys, k.fetched = url, map[string]ed25519.PublicKey{}, time.Time{}
+	}
+}
+
+// fetch replaces the cached keys with the keys served at url, it must be called without k.mu.
+func (k *keySet) fetch(ctx context.Context, url string, host string) error {
+	logger := logging.FromContext(ctx, k.log)
+
+	// callers missing a key while the fetch is in flight join it rather than give up
+	defer func() {
+		k.mu.Lock()
+		k.use(url)
+		k.fetched = time.Now()
+		k.mu.Unlock()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrKeysUnavailable, err)
+	}
+
+	req.Host = host
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		logger.WithField("url", url).WithError(err).Error("Failed to fetch token verification keys")
+
+		return fmt.Errorf("%w: %w", ErrKeysUnavailable, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.WithFields(log.Fields{"url": url, "status": resp.StatusCode}).Error("Failed to fetch token verification keys")
+
+		return fmt.Errorf("%w: %s returned %d", ErrKeysUnavailable, url, resp.StatusCode)
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrKeysUnavailable, err)
+	}
+
+	keys := map[string]ed25519.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Kty != "OKP" || key.Crv != "Ed25519" {
+			continue
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			logger.WithField("kid", key.Kid).Warn("Ignoring malformed token verification key")
+
+			continue
+		}
+
+		keys[key.Kid] = ed25519.PublicKey(x)
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("%w: %s has no Ed25519 keys", ErrKeysUnavailable, url)
+	}
+
+	k.mu.Lock()
+	k.use(url)
+	k.keys = keys
+	k.mu.Unlock()
+
+	logger.WithField("keys", len(keys)).Debug("Fetched token verification keys")
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	_config "github.com/pavr1/people_project/people/config"
+)
+
+func TestKeySetReadsWhileFetching(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	fetches := atomic.Int32{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "auth.internal" {
+			t.Errorf("host = %q, want auth.internal", r.Host)
+		}
+
+		// the first fetch is answered right away, the next ones once released
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jwk{{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(public), Kid: "1"}},
+		})
+	}))
+	defer server.Close()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	keys := newKeySet(logger, server.Client())
+
+	_, err = keys.key(context.Background(), server.URL, "auth.internal", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed := make(chan error)
+	go func() {
+		refreshed <- keys.refresh(context.Background(), server.URL, "auth.internal")
+	}()
+
+	for fetches.Load() < 2 {
+		runtime.Gosched()
+	}
+
+	// a refresh is in flight, cached keys are returned without waiting for it
+	_, err = keys.key(context.Background(), server.URL, "auth.internal", "1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	close(release)
+
+	err = <-refreshed
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunFollowsReloadedRefreshInterval(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetches := atomic.Int32{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jwk{{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(public), Kid: "1"}},
+		})
+	}))
+	defer server.Close()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	config := &_config.Config{}
+	config.Auth.Mode = _config.AuthModeLocal
+	config.Auth.JWKSUrl = server.URL
+	config.Auth.RefreshInterval = time.Hour
+
+	a := NewAuth(logger, config, server.Client())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go a.Run(ctx)
+
+	for fetches.Load() < 1 {
+		runtime.Gosched()
+	}
+
+	reloaded := *config
+	reloaded.Auth.RefreshInterval = time.Millisecond
+	a.SetConfig(&reloaded)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fetches.Load() < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("fetches = %d, want the keys refreshed at the reloaded interval", fetches.Load())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
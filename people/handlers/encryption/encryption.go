@@ -0,0 +1,353 @@
+// Package encryption encrypts person fields at rest with envelope encryption.
+//
+// Every document gets its own AES-GCM data key, stored next to the fields wrapped by the current
+// master key of the keyring. Encrypted fields also get a <field>Index blind index, an HMAC of the
+// value, so equality lookups keep working without decrypting.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/pavr1/people_project/people/config"
+)
+
+const (
+	// EnvelopeField holds the wrapped data key of a document
+	EnvelopeField = "encryption"
+	indexSuffix   = "Index"
+	keySize       = 32
+)
+
+// Fields are the person fields that may be encrypted.
+var Fields = []string{"name", "lastName"}
+
+// keyring is the JSON document holding the master keys, base64 encoded.
+type keyring struct {
+	// Current names the master key new data keys are wrapped with
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+	// IndexKey is the HMAC key of the blind indexes, existing indexes change on the next rotation
+	IndexKey string `json:"indexKey"`
+}
+
+type envelope struct {
+	KeyID   string   `bson:"keyId"`
+	DataKey []byte   `bson:"dataKey"`
+	Fields  []string `bson:"fields"`
+}
+
+type Encryptor struct {
+	log      *log.Logger
+	fields   map[string]bool
+	current  string
+	keys     map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// NewEncryptor loads the keyring from the configured file or value. Without encrypted fields
+// the encryptor stores everything in plaintext but still decrypts existing documents when a
+// keyring is set.
+func NewEncryptor(log *log.Logger, config *config.Config) (*Encryptor, error) {
+	encryptor := &Encryptor{
+		log:    log,
+		fields: map[string]bool{},
+		keys:   map[string]cipher.AEAD{},
+	}
+
+	for _, field := range config.Encryption.Fields {
+		if !isSupported(field) {
+			return nil, fmt.Errorf("field %s cannot be encrypted, supported fields are %v", field, Fields)
+		}
+
+		encryptor.fields[field] = true
+	}
+
+	data := []byte(config.Encryption.Keyring.Reveal())
+	if config.Encryption.KeyringFile != "" {
+		fileData, err := os.ReadFile(config.Encryption.KeyringFile)
+		if err != nil {
+			log.WithError(err).Error("Failed to read encryption keyring")
+
+			return nil, err
+		}
+
+		data = fileData
+	}
+
+	if len(data) == 0 {
+		if len(encryptor.fields) > 0 {
+			return nil, errors.New("encrypted fields need an encryption keyring")
+		}
+
+		return encryptor, nil
+	}
+
+	ring := keyring{}
+	err := json.Unmarshal(data, &ring)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse encryption keyring")
+
+		return nil, err
+	}
+
+	for id, encoded := range ring.Keys {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %s: %w", id, err)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		encryptor.keys[id] = aead
+	}
+
+	if _, ok := encryptor.keys[ring.Current]; !ok {
+		return nil, fmt.Errorf("current master key %q is not in the keyring", ring.Current)
+	}
+
+	encryptor.current = ring.Current
+
+	encryptor.indexKey, err = decodeKey(ring.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("index key: %w", err)
+	}
+
+	log.WithField("fields", config.Encryption.Fields).WithField("key", ring.Current).Info("Loaded encryption keyring")
+
+	return encryptor, nil
+}
+
+func isSupported(field string) bool {
+	for _, supported := range Fields {
+		if field == supported {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted reports whether new values of the field are encrypted.
+func (e *Encryptor) IsEncrypted(field string) bool {
+	return e.fields[field]
+}
+
+// IndexField is the field holding the blind index of field.
+func IndexField(field string) string {
+	return field + indexSuffix
+}
+
+// BlindIndex is the value stored in IndexField(field) for value.
+func (e *Encryptor) BlindIndex(field string, value string) string {
+	mac := hmac.New(sha256.New, e.indexKey)
+	mac.Write([]byte(field + "\x00" + value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Seal returns the fields as they are stored: plaintext, or encrypted under a new data key
+// followed by their blind indexes and the envelope.
+func (e *Encryptor) Seal(fields bson.D) (bson.D, error) {
+	encrypted := []string{}
+	for _, field := range fields {
+		if e.fields[field.Key] {
+			encrypted = append(encrypted, field.Key)
+		}
+	}
+
+	if len(encrypted) == 0 {
+		return fields, nil
+	}
+
+	dataKey := make([]byte, keySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := bson.D{}
+	indexes := bson.D{}
+
+	for _, field := range fields {
+		if !e.fields[field.Key] {
+			sealed = append(sealed, field)
+
+			continue
+		}
+
+		value, ok := field.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("field %s must be a string to be encrypted", field.Key)
+		}
+
+		ciphertext, err := seal(aead, []byte(value), field.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		sealed = append(sealed, bson.E{Key: field.Key, Value: ciphertext})
+		indexes = append(indexes, bson.E{Key: IndexField(field.Key), Value: e.BlindIndex(field.Key, value)})
+	}
+
+	wrapped, err := seal(e.keys[e.current], dataKey, e.current)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed = append(sealed, indexes...)
+	sealed = append(sealed, bson.E{Key: EnvelopeField, Value: envelope{KeyID: e.current, DataKey: wrapped, Fields: encrypted}})
+
+	return sealed, nil
+}
+
+// Open decrypts the fields of a stored document in place and removes the envelope and blind
+// indexes. Documents without an envelope are left as they are.
+func (e *Encryptor) Open(doc bson.M) error {
+	env, ok, err := parseEnvelope(doc)
+	if err != nil || !ok {
+		return err
+	}
+
+	master, ok := e.keys[env.KeyID]
+	if !ok {
+		return fmt.Errorf("master key %q is not in the keyring", env.KeyID)
+	}
+
+	dataKey, err := open(master, env.DataKey, env.KeyID)
+	if err != nil {
+		return fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range env.Fields {
+		delete(doc, IndexField(field))
+
+		// values written in plaintext after the envelope, e.g. once encryption was turned off
+		ciphertext, ok := binaryValue(doc[field])
+		if !ok {
+			continue
+		}
+
+		plaintext, err := open(aead, ciphertext, field)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", field, err)
+		}
+
+		doc[field] = string(plaintext)
+	}
+
+	delete(doc, EnvelopeField)
+
+	return nil
+}
+
+// WrappedKey returns the wrapped data key of a stored document, nil when it has no envelope.
+func WrappedKey(doc bson.M) []byte {
+	env, ok, err := parseEnvelope(doc)
+	if err != nil || !ok {
+		return nil
+	}
+
+	return env.DataKey
+}
+
+func parseEnvelope(doc bson.M) (envelope, bool, error) {
+	env := envelope{}
+
+	raw, ok := doc[EnvelopeField]
+	if !ok {
+		return env, false, nil
+	}
+
+	data, err := bson.Marshal(raw)
+	if err != nil {
+		return env, false, err
+	}
+
+	err = bson.Unmarshal(data, &env)
+	if err != nil {
+		return env, false, err
+	}
+
+	return env, true, nil
+}
+
+func binaryValue(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case primitive.Binary:
+		return v.Data, true
+	case []byte:
+		return v, true
+	}
+
+	return nil, false
+}
+
+// seal encrypts plaintext bound to aad and prepends the nonce.
+func seal(aead cipher.AEAD, plaintext []byte, aad string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, []byte(aad)), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, aad string) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, []byte(aad))
+}
@@ -0,0 +1,251 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/pavr1/people_project/people/config"
+	"github.com/pavr1/people_project/shared/secrets"
+)
+
+// testKey is a master or index key made of seed bytes.
+func testKey(seed byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{seed}, keySize))
+}
+
+// newTestEncryptor encrypts fields with a keyring of the keys by ID, current wrapping new data keys.
+func newTestEncryptor(t *testing.T, current string, keys map[string]string, indexKey string, fields ...string) *Encryptor {
+	t.Helper()
+
+	data, err := json.Marshal(keyring{Current: current, Keys: keys, IndexKey: indexKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	testConfig := &config.Config{}
+	testConfig.Encryption.Fields = fields
+	testConfig.Encryption.Keyring = secrets.New(string(data))
+
+	encryptor, err := NewEncryptor(logger, testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encryptor
+}
+
+// stored returns the sealed fields the way MongoDB returns the stored document.
+func stored(t *testing.T, sealed bson.D) bson.M {
+	t.Helper()
+
+	data, err := bson.Marshal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := bson.M{}
+
+	err = bson.Unmarshal(data, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+var testPerson = bson.D{{Key: "name", Value: "Ada"}, {Key: "lastName", Value: "Lovelace"}}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+	}{
+		{name: "every field", fields: []string{"name", "lastName"}},
+		{name: "one field", fields: []string{"lastName"}},
+		{name: "no field", fields: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encryptor := newTestEncryptor(t, "k1", map[string]string{"k1": testKey(1)}, testKey(9), test.fields...)
+
+			sealed, err := encryptor.Seal(testPerson)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc := stored(t, sealed)
+
+			for _, field := range testPerson {
+				_, isPlaintext := doc[field.Key].(string)
+				_, hasIndex := doc[IndexField(field.Key)]
+
+				if encrypted := encryptor.IsEncrypted(field.Key); isPlaintext == encrypted || hasIndex != encrypted {
+					t.Errorf("%s is stored as %#v with index %t, want encrypted %t", field.Key, doc[field.Key], hasIndex, encrypted)
+				}
+			}
+
+			_, hasEnvelope := doc[EnvelopeField]
+			if hasEnvelope != (len(test.fields) > 0) {
+				t.Errorf("envelope stored = %t, want %t", hasEnvelope, len(test.fields) > 0)
+			}
+
+			err = encryptor.Open(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := stored(t, testPerson); !reflect.DeepEqual(doc, want) {
+				t.Errorf("opened = %v, want %v", doc, want)
+			}
+		})
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	encryptor := newTestEncryptor(t, "k1", map[string]string{"k1": testKey(1)}, testKey(9), "name", "lastName")
+
+	tests := []struct {
+		name      string
+		encryptor *Encryptor
+		tamper    func(doc bson.M)
+		err       string
+	}{
+		{
+			name:      "wrong master key",
+			encryptor: newTestEncryptor(t, "k1", map[string]string{"k1": testKey(2)}, testKey(9)),
+			err:       "failed to unwrap data key",
+		},
+		{
+			name:      "missing master key",
+			encryptor: newTestEncryptor(t, "k2", map[string]string{"k2": testKey(2)}, testKey(9)),
+			err:       `master key "k1" is not in the keyring`,
+		},
+		{
+			name:   "tampered ciphertext",
+			tamper: func(doc bson.M) { doc["name"].(primitive.Binary).Data[20] ^= 1 },
+			err:    "failed to decrypt name",
+		},
+		{
+			name:   "ciphertext of another field",
+			tamper: func(doc bson.M) { doc["name"], doc["lastName"] = doc["lastName"], doc["name"] },
+			err:    "failed to decrypt",
+		},
+		{
+			name:   "tampered wrapped data key",
+			tamper: func(doc bson.M) { doc[EnvelopeField].(bson.M)["dataKey"].(primitive.Binary).Data[20] ^= 1 },
+			err:    "failed to unwrap data key",
+		},
+		{
+			name:   "wrapped data key relabelled",
+			tamper: func(doc bson.M) { doc[EnvelopeField].(bson.M)["keyId"] = "k2" },
+			err:    `master key "k2" is not in the keyring`,
+		},
+		{
+			name:   "truncated ciphertext",
+			tamper: func(doc bson.M) { doc["name"] = primitive.Binary{Data: []byte{1, 2, 3}} },
+			err:    "ciphertext is too short",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sealed, err := encryptor.Seal(testPerson)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc := stored(t, sealed)
+			if test.tamper != nil {
+				test.tamper(doc)
+			}
+
+			opener := encryptor
+			if test.encryptor != nil {
+				opener = test.encryptor
+			}
+
+			err = opener.Open(doc)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestOpenWithRetiredMasterKey(t *testing.T) {
+	before := newTestEncryptor(t, "k1", map[string]string{"k1": testKey(1)}, testKey(9), "name")
+	after := newTestEncryptor(t, "k2", map[string]string{"k1": testKey(1), "k2": testKey(2)}, testKey(9), "name")
+
+	sealed, err := before.Seal(testPerson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := stored(t, sealed)
+
+	err = after.Open(doc)
+	if err != nil || doc["name"] != "Ada" {
+		t.Errorf("opened %v, %v, want the name decrypted with the retired key", doc, err)
+	}
+
+	sealed, err = after.Seal(testPerson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keyID := stored(t, sealed)[EnvelopeField].(bson.M)["keyId"]; keyID != "k2" {
+		t.Errorf("new data keys are wrapped with %v, want k2", keyID)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	encryptor := newTestEncryptor(t, "k1", map[string]string{"k1": testKey(1)}, testKey(9), "name")
+	otherIndexKey := newTestEncryptor(t, "k1", map[string]string{"k1": testKey(1)}, testKey(8), "name")
+	sameIndexKey := newTestEncryptor(t, "k2", map[string]string{"k2": testKey(2)}, testKey(9), "name")
+
+	index := encryptor.BlindIndex("name", "Ada")
+
+	tests := []struct {
+		name  string
+		index string
+		equal bool
+	}{
+		{name: "same value", index: encryptor.BlindIndex("name", "Ada"), equal: true},
+		{name: "same index key, other master key", index: sameIndexKey.BlindIndex("name", "Ada"), equal: true},
+		{name: "other value", index: encryptor.BlindIndex("name", "Ava"), equal: false},
+		{name: "other field", index: encryptor.BlindIndex("lastName", "Ada"), equal: false},
+		{name: "field and value boundary", index: encryptor.BlindIndex("nam", "eAda"), equal: false},
+		{name: "other index key", index: otherIndexKey.BlindIndex("name", "Ada"), equal: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if (test.index == index) != test.equal {
+				t.Errorf("index %s equal to %s = %t, want %t", test.index, index, !test.equal, test.equal)
+			}
+		})
+	}
+
+	// Lookups find sealed documents by the index of the plaintext
+	sealed, err := encryptor.Seal(testPerson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored(t, sealed)[IndexField("name")] != index {
+		t.Error("the stored index is not the index of the value")
+	}
+}
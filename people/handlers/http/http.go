@@ -1,104 +1,192 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/pavr1/people_project/people/config"
 	"github.com/pavr1/people_project/people/handlers/auth"
+	"github.com/pavr1/people_project/people/handlers/policy"
 	repohandler "github.com/pavr1/people_project/people/handlers/repo"
 	"github.com/pavr1/people_project/people/models"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/ratelimit"
 )
 
 type HttpHandler struct {
-	log  *log.Logger
-	repo *repohandler.RepoHandler
-	auth *auth.Auth
+	log     *log.Logger
+	repo    *repohandler.RepoHandler
+	stats   repohandler.StatsStore
+	auth    *auth.Auth
+	policy  *policy.Policy
+	limiter *ratelimit.Limiter
 }
 
-func NewHttpHandler(auth *auth.Auth, repo *repohandler.RepoHandler, log *log.Logger) *HttpHandler {
+func NewHttpHandler(auth *auth.Auth, repo *repohandler.RepoHandler, stats repohandler.StatsStore, policy *policy.Policy, limiter *ratelimit.Limiter, log *log.Logger) *HttpHandler {
 	return &HttpHandler{
-		auth: auth,
-		repo: repo,
-		log:  log,
+		auth:    auth,
+		repo:    repo,
+		stats:   stats,
+		policy:  policy,
+		limiter: limiter,
+		log:     log,
 	}
 }
 
-type responseWriter struct {
-	http.ResponseWriter
-	statusCode int
+// logger is the logger of the request ctx belongs to, carrying its request ID.
+func (h *HttpHandler) logger(ctx context.Context) *log.Entry {
+	return logging.FromContext(ctx, h.log)
 }
 
-func newResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{w, 200}
+// Route is the middleware.RouteFunc of the gorilla routes, their path template.
+func Route(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return r.URL.Path
+	}
+
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return r.URL.Path
+	}
+
+	return path
 }
 
-func (h *HttpHandler) Middleware(peopleHandler http.HandlerFunc, prometheusHandler http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		timeStart := time.Now()
-		peopleHandler.ServeHTTP(w, r)
-		timeEnd := time.Now()
+func (h *HttpHandler) GetPersonList(w http.ResponseWriter, r *http.Request) {
+	h.logger(r.Context()).Info("GetPersonList")
 
-		route := mux.CurrentRoute(r)
-		path, err := route.GetPathTemplate()
-		if err != nil {
-			h.log.WithError(err).Error("Failed to get path template")
-		}
+	claims, isValid := h.validate(r, w, http.MethodGet)
+	if !isValid {
+		return
+	}
+
+	offset, err := parseQueryInt(r, "offset")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	limit, err := parseQueryInt(r, "limit")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+
+		return
+	}
 
-		rw := newResponseWriter(w)
+	people, err := h.repo.GetPersonList(r.Context(), claims.Principal(), parsePersonFilter(r), offset, limit)
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		w.Write([]byte(err.Error()))
 
-		r.Header.Add("X-Request-Path", path)
-		r.Header.Add("X-Response-Status", string(rw.statusCode))
-		r.Header.Add("X-Response-Time", timeEnd.Sub(timeStart).String())
+		return
+	}
 
-		h.log.WithFields(log.Fields{"path": r.Header.Get("X-Request-Path"), "status": r.Header.Get("X-Response-Status"), "time": r.Header.Get("X-Response-Time")}).Info("Middleware Completed")
+	bytes, err := json.Marshal(people)
+	if err != nil {
+		h.logger(r.Context()).WithError(err).Error("Failed to marshal person list")
 
-		prometheusHandler.ServeHTTP(w, r)
-	})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
 }
 
-func (h *HttpHandler) GetPersonList(w http.ResponseWriter, r *http.Request) {
-	h.log.Info("GetPersonList")
+func (h *HttpHandler) GetPersonStats(w http.ResponseWriter, r *http.Request) {
+	h.logger(r.Context()).Info("GetPersonStats")
 
-	isValid := h.validate(r, w, http.MethodGet)
+	claims, isValid := h.validate(r, w, http.MethodGet)
 	if !isValid {
 		return
 	}
 
-	people, err := h.repo.GetPersonList()
+	statsOptions := models.StatsOptions{
+		AgeBuckets: h.repo.Config.Stats.AgeBuckets,
+		Top:        h.repo.Config.Stats.Top,
+		GroupBy:    r.URL.Query().Get("groupBy"),
+		Filter:     parsePersonFilter(r),
+	}
+
+	if value := r.URL.Query().Get("buckets"); value != "" {
+		buckets, err := config.ParseAgeBuckets(value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+
+			return
+		}
+
+		statsOptions.AgeBuckets = buckets
+	}
+
+	top, err := parseQueryInt(r, "top")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 
 		return
 	}
 
-	bytes, err := json.Marshal(people)
+	if top > 0 {
+		statsOptions.Top = top
+	}
+
+	stats, err := h.stats.GetPersonStats(r.Context(), claims.Principal(), statsOptions)
+	if err != nil {
+		if errors.Is(err, repohandler.ErrNotIndexed) || errors.Is(err, repohandler.ErrEncrypted) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+		} else {
+			w.WriteHeader(errorStatus(err))
+			w.Write([]byte(err.Error()))
+		}
+
+		return
+	}
+
+	bytes, err := json.Marshal(stats)
 	if err != nil {
-		h.log.WithError(err).Error("Failed to marshal person list")
+		h.logger(r.Context()).WithError(err).Error("Failed to marshal person stats")
 
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
+
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(bytes)
 }
 
 func (h *HttpHandler) GetPerson(w http.ResponseWriter, r *http.Request) {
-	h.log.Info("GetPerson")
+	h.logger(r.Context()).Info("GetPerson")
 
-	isValid := h.validate(r, w, http.MethodGet)
+	claims, isValid := h.validate(r, w, http.MethodGet)
 	if !isValid {
 		return
 	}
 
-	id := r.PathValue("id")
+	id := mux.Vars(r)["id"]
 
 	if id == "" {
 		w.WriteHeader(http.StatusBadRequest)
@@ -107,17 +195,17 @@ func (h *HttpHandler) GetPerson(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	person, err := h.repo.GetPerson(id)
+	person, err := h.repo.GetPerson(r.Context(), claims.Principal(), id)
 	if err != nil {
 		//will need to check for not found
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(err))
 		w.Write([]byte(err.Error()))
 
 		return
 	}
 
 	if person == nil {
-		w.WriteHeader(http.StatusConflict)
+		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("Person not found"))
 
 		return
@@ -125,20 +213,23 @@ func (h *HttpHandler) GetPerson(w http.ResponseWriter, r *http.Request) {
 
 	bytes, err := json.Marshal(person)
 	if err != nil {
-		h.log.WithError(err).Error("Failed to marshal person")
+		h.logger(r.Context()).WithError(err).Error("Failed to marshal person")
 
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
+
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(bytes)
 }
 
 func (h *HttpHandler) CreatePerson(w http.ResponseWriter, r *http.Request) {
-	h.log.Info("CreatePerson")
+	h.logger(r.Context()).Info("CreatePerson")
 
-	isValid := h.validate(r, w, http.MethodPost)
+	claims, isValid := h.validate(r, w, http.MethodPost)
 	if !isValid {
 		return
 	}
@@ -146,29 +237,38 @@ func (h *HttpHandler) CreatePerson(w http.ResponseWriter, r *http.Request) {
 	// Read the request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		h.log.WithError(err).Error("Failed to read request body")
+		h.logger(r.Context()).WithError(err).Error("Failed to read request body")
 
 		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
 		return
 	}
 
+	principal := claims.Principal()
+
+	h.idempotent(w, r, principal, body, func(w http.ResponseWriter) {
+		h.createPerson(r.Context(), w, principal, body)
+	})
+}
+
+func (h *HttpHandler) createPerson(ctx context.Context, w http.ResponseWriter, principal *models.Principal, body []byte) {
 	person := models.Person{}
-	err = json.Unmarshal(body, &person)
+	err := json.Unmarshal(body, &person)
 	if err != nil {
-		h.log.WithError(err).Error("Failed to unmarshal request body")
+		h.logger(ctx).WithError(err).Error("Failed to unmarshal request body")
 
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	err = h.repo.CreatePerson(&person)
+	err = h.repo.CreatePerson(ctx, principal, &person)
 	if err != nil {
 		if strings.Contains(err.Error(), "already exists") {
 			w.WriteHeader(http.StatusConflict)
 			w.Write([]byte(err.Error()))
 		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(errorStatus(err))
 			w.Write([]byte(err.Error()))
 		}
 
@@ -181,9 +281,9 @@ func (h *HttpHandler) CreatePerson(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HttpHandler) UpdatePerson(w http.ResponseWriter, r *http.Request) {
-	h.log.Info("UpdatePerson")
+	h.logger(r.Context()).Info("UpdatePerson")
 
-	isValid := h.validate(r, w, http.MethodPut)
+	claims, isValid := h.validate(r, w, http.MethodPut)
 	if !isValid {
 		return
 	}
@@ -191,9 +291,10 @@ func (h *HttpHandler) UpdatePerson(w http.ResponseWriter, r *http.Request) {
 	// Read the request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		h.log.WithError(err).Error("Failed to read request body")
+		h.logger(r.Context()).WithError(err).Error("Failed to read request body")
 
 		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
 		return
 	}
 
@@ -201,9 +302,10 @@ func (h *HttpHandler) UpdatePerson(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &person)
 	if err != nil {
-		h.log.WithError(err).Error("Failed to unmarshal request body")
+		h.logger(r.Context()).WithError(err).Error("Failed to unmarshal request body")
 
 		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
 		return
 	}
 
@@ -233,9 +335,9 @@ func (h *HttpHandler) UpdatePerson(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.repo.UpdatePerson(&person)
+	err = h.repo.UpdatePerson(r.Context(), claims.Principal(), &person)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(err))
 		w.Write([]byte(err.Error()))
 
 		return
@@ -246,14 +348,14 @@ func (h *HttpHandler) UpdatePerson(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HttpHandler) DeletePerson(w http.ResponseWriter, r *http.Request) {
-	h.log.Info("DeletePerson")
+	h.logger(r.Context()).Info("DeletePerson")
 
-	isValid := h.validate(r, w, http.MethodDelete)
+	claims, isValid := h.validate(r, w, http.MethodDelete)
 	if !isValid {
 		return
 	}
 
-	id := r.PathValue("id")
+	id := mux.Vars(r)["id"]
 
 	if id == "" {
 		w.WriteHeader(http.StatusBadRequest)
@@ -262,9 +364,9 @@ func (h *HttpHandler) DeletePerson(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.repo.DeletePerson(id)
+	err := h.repo.DeletePerson(r.Context(), claims.Principal(), id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(err))
 		w.Write([]byte(err.Error()))
 
 		return
@@ -274,89 +376,324 @@ func (h *HttpHandler) DeletePerson(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Person with id " + id + " successfully deleted"))
 }
 
-func (h *HttpHandler) validate(r *http.Request, w http.ResponseWriter, method string) bool {
-	isValid := h.isValidRequest(r, w, method)
+func (h *HttpHandler) GrantPerson(w http.ResponseWriter, r *http.Request) {
+	h.logger(r.Context()).Info("GrantPerson")
+
+	claims, isValid := h.validate(r, w, http.MethodPut)
 	if !isValid {
-		return false
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("ID is required"))
+
+		return
 	}
 
-	isValid = h.isValidToken(r, w)
+	// Read the request body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.logger(r.Context()).WithError(err).Error("Failed to read request body")
 
-	return isValid
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	grants := models.Grants{}
+
+	err = json.Unmarshal(body, &grants)
+	if err != nil {
+		h.logger(r.Context()).WithError(err).Error("Failed to unmarshal request body")
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	for _, grantee := range append(grants.Readers, grants.Writers...) {
+		if !strings.HasPrefix(grantee, "user:") && !strings.HasPrefix(grantee, "group:") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Grantees must start with user: or group:"))
+
+			return
+		}
+	}
+
+	err = h.repo.SetGrants(r.Context(), claims.Principal(), id, &grants)
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Person with id " + id + " grants successfully updated"))
 }
 
-func (h *HttpHandler) isValidToken(r *http.Request, w http.ResponseWriter) bool {
-	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+func (h *HttpHandler) ExportPerson(w http.ResponseWriter, r *http.Request) {
+	h.logger(r.Context()).Info("ExportPerson")
+
+	claims, isValid := h.validate(r, w, http.MethodGet)
+	if !isValid {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("ID is required"))
+
+		return
+	}
+
+	export, err := h.repo.ExportPerson(r.Context(), claims.Principal(), id)
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	if export == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Person not found"))
 
-	resCode, body, err := h.auth.IsValidToken(token)
+		return
+	}
+
+	bytes, err := json.Marshal(export)
 	if err != nil {
+		h.logger(r.Context()).WithError(err).Error("Failed to marshal person export")
+
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
-		h.log.Warn("Failed to validate token")
 
-		return false
+		return
 	}
 
-	if resCode != http.StatusOK {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(body))
-		h.log.Warn("Invalid token")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "person-"+id+".json"))
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
+
+func (h *HttpHandler) ErasePerson(w http.ResponseWriter, r *http.Request) {
+	h.logger(r.Context()).Info("ErasePerson")
+
+	claims, isValid := h.validate(r, w, http.MethodDelete)
+	if !isValid {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("ID is required"))
+
+		return
+	}
+
+	receipt, err := h.repo.ErasePerson(r.Context(), claims.Principal(), id)
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	bytes, err := json.Marshal(receipt)
+	if err != nil {
+		h.logger(r.Context()).WithError(err).Error("Failed to marshal erasure receipt")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
+
+func (h *HttpHandler) GetErasureLog(w http.ResponseWriter, r *http.Request) {
+	h.logger(r.Context()).Info("GetErasureLog")
+
+	claims, isValid := h.validate(r, w, http.MethodGet)
+	if !isValid {
+		return
+	}
+
+	erasureLog, err := h.repo.GetErasureLog(r.Context(), claims.Tenant)
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	if !erasureLog.Valid {
+		h.logger(r.Context()).WithField("problem", erasureLog.Problem).Error("Erasure receipt chain is broken")
+	}
+
+	bytes, err := json.Marshal(erasureLog)
+	if err != nil {
+		h.logger(r.Context()).WithError(err).Error("Failed to marshal erasure log")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
+
+func (h *HttpHandler) validate(r *http.Request, w http.ResponseWriter, method string) (*auth.Claims, bool) {
+	isValid := h.isValidRequest(r, w, method)
+	if !isValid {
+		return nil, false
+	}
+
+	claims, isValid := h.isValidToken(r, w)
+	if !isValid {
+		return nil, false
+	}
+
+	// Only a verified token picks the bucket charged, the tenant keeps equal names apart. Requests
+	// failing verification are charged to their client IP before they are rejected.
+	if !h.limiter.Allow(w, r, "user:"+claims.Tenant+"/"+claims.UserName) {
+		return nil, false
+	}
+
+	isValid = h.isAllowed(r, w, claims)
+
+	return claims, isValid
+}
+
+func (h *HttpHandler) isAllowed(r *http.Request, w http.ResponseWriter, claims *auth.Claims) bool {
+	path, err := mux.CurrentRoute(r).GetPathTemplate()
+	if err != nil {
+		h.logger(r.Context()).WithError(err).Error("Failed to get path template")
+	}
+
+	if !h.policy.IsAllowed(path, r.Method, claims.Roles) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Forbidden"))
+		h.logger(r.Context()).WithFields(log.Fields{"route": path, "method": r.Method, "roles": claims.Roles}).Warn("Access denied")
 
 		return false
 	}
 
-	h.log.Info("Valid token")
 	return true
 }
 
+func (h *HttpHandler) isValidToken(r *http.Request, w http.ResponseWriter) (*auth.Claims, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	claims, err := h.auth.IsValidToken(r.Context(), token)
+	if err != nil && !h.limiter.AllowClient(w, r) {
+		return nil, false
+	}
+
+	if errors.Is(err, auth.ErrInvalidToken) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(err.Error()))
+		h.logger(r.Context()).WithError(err).Warn("Invalid token")
+
+		return nil, false
+	}
+
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		w.Write([]byte(err.Error()))
+		h.logger(r.Context()).WithError(err).Warn("Failed to validate token")
+
+		return nil, false
+	}
+
+	h.logger(r.Context()).WithField("tenant", claims.Tenant).Info("Valid token")
+	return claims, true
+}
+
 func (h *HttpHandler) isValidRequest(r *http.Request, w http.ResponseWriter, method string) bool {
 	if r.Method != method {
 		http.Error(w, "Invalid request method", http.StatusBadRequest)
-		h.log.Warn("Invalid request method")
+		h.logger(r.Context()).Warn("Invalid request method")
 		return false
 	}
 
 	if r.Header.Get("Authorization") == "" {
+		if !h.limiter.AllowClient(w, r) {
+			return false
+		}
+
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Authorization header is required"))
-		h.log.Warn("Authorization header is required")
+		h.logger(r.Context()).Warn("Authorization header is required")
 
 		return false
 	}
 
 	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		if !h.limiter.AllowClient(w, r) {
+			return false
+		}
+
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Authorization header is invalid"))
-		h.log.Warn("Authorization header is invalid")
+		h.logger(r.Context()).Warn("Authorization header is invalid")
 
 		return false
 	}
 
-	h.log.Info("Valid request")
+	h.logger(r.Context()).Info("Valid request")
 	return true
 }
 
-func (h *HttpHandler) PrometheusLog(w http.ResponseWriter, r *http.Request) {
-	h.log.WithFields(log.Fields{"path": r.Header.Get("X-Request-Path"), "status": r.Header.Get("X-Response-Status"), "time": r.Header.Get("X-Response-Time")}).Info("Middleware Prometheus")
-
-	//pvillalobos - hardcoded path needs to be added to env.
-	req, err := http.NewRequest("GET", "http://prometheus:9000/prometheus/log", nil)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		h.log.WithError(err).Error("Failed to create prometheus request")
+// parseQueryInt reads an optional non-negative integer query parameter, defaulting to 0.
+func parseQueryInt(r *http.Request, name string) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
 
-		return
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
 	}
 
-	req.Header = r.Header
+	return number, nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		h.log.WithError(err).Error("Failed to send prometheus request")
+// errorStatus is the response status of a failed repository or auth call: 404 when no person
+// matched, 504 when it ran out of time, 500 otherwise.
+func errorStatus(err error) int {
+	if errors.Is(err, repohandler.ErrNotFound) {
+		return http.StatusNotFound
+	}
 
-		return
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return http.StatusGatewayTimeout
 	}
 
-	defer resp.Body.Close()
+	return http.StatusInternalServerError
+}
+
+// parsePersonFilter reads the exact match query parameters shared by the list and stats endpoints.
+func parsePersonFilter(r *http.Request) models.PersonFilter {
+	return models.PersonFilter{
+		Name:     r.URL.Query().Get("name"),
+		LastName: r.URL.Query().Get("lastName"),
+	}
 }
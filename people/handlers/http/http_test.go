@@ -0,0 +1,194 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	_config "github.com/pavr1/people_project/people/config"
+	"github.com/pavr1/people_project/people/handlers/auth"
+	"github.com/pavr1/people_project/people/handlers/openapi"
+	"github.com/pavr1/people_project/people/handlers/policy"
+	repohandler "github.com/pavr1/people_project/people/handlers/repo"
+	"github.com/pavr1/people_project/shared/ratelimit"
+)
+
+// testTokens are the tokens the fake auth service accepts, by the role of their claims.
+var testTokens = map[string]auth.Claims{
+	"viewer-token": {UserName: "carol", Tenant: "default", Roles: []string{"viewer"}},
+	"editor-token": {UserName: "bob", Tenant: "default", Roles: []string{"editor"}},
+	"dave-token":   {UserName: "dave", Tenant: "default", Roles: []string{"viewer"}},
+}
+
+// newTestAuthServer verifies the testTokens like the auth service does in remote mode.
+func newTestAuthServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := testTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("token is malformed"))
+
+			return
+		}
+
+		json.NewEncoder(w).Encode(claims)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newTestRouter serves h behind the strict validation of the people specification, a handler
+// drifting from it answers 500.
+func newTestRouter(t *testing.T, h *HttpHandler) http.Handler {
+	t.Helper()
+
+	config := &_config.Config{}
+	config.OpenApi.Strict = true
+
+	openApiHandler, err := openapi.NewOpenApiHandler(h.log, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/person/list", openApiHandler.Middleware(http.HandlerFunc(h.GetPersonList)))
+	router.Handle("/person/stats", openApiHandler.Middleware(http.HandlerFunc(h.GetPersonStats)))
+	router.Handle("/person/create", openApiHandler.Middleware(http.HandlerFunc(h.CreatePerson)))
+	router.Handle("/person/delete/{id}", openApiHandler.Middleware(http.HandlerFunc(h.DeletePerson)))
+	router.Handle("/person/{id}", openApiHandler.Middleware(http.HandlerFunc(h.GetPerson)))
+
+	return router
+}
+
+// newTestHandler returns a handler verifying tokens with the fake auth service and the default
+// policy, rate limiting every route to limit. The repository is left to the tests reaching it.
+func newTestHandler(t *testing.T, limit ratelimit.Limit) *HttpHandler {
+	t.Helper()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	config := &_config.Config{}
+	config.Auth.Mode = _config.AuthModeRemote
+	config.Auth.Path = newTestAuthServer(t).URL + "/auth/token"
+
+	accessPolicy, err := policy.NewPolicy(logger, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter := ratelimit.NewLimiter(logger, ratelimit.NewMemoryBackend(time.Minute, 0), ratelimit.Options{Default: limit, Route: Route})
+
+	return NewHttpHandler(auth.NewAuth(logger, config, http.DefaultClient), nil, nil, accessPolicy, limiter, logger)
+}
+
+func TestRejectedRequestsMatchSpecification(t *testing.T) {
+	router := newTestRouter(t, newTestHandler(t, ratelimit.Limit{}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+	}{
+		{name: "list without token", method: http.MethodGet, path: "/person/list", status: http.StatusUnauthorized},
+		{name: "list with invalid token", method: http.MethodGet, path: "/person/list", token: "forged", status: http.StatusUnauthorized},
+		{name: "list with invalid limit", method: http.MethodGet, path: "/person/list?limit=-1", token: "viewer-token", status: http.StatusBadRequest},
+		{name: "stats with invalid bucket", method: http.MethodGet, path: "/person/stats?buckets=x", token: "viewer-token", status: http.StatusBadRequest},
+		{name: "create as viewer", method: http.MethodPost, path: "/person/create", token: "viewer-token", body: `{"id":"1","name":"Ada","lastName":"Lovelace","age":36}`, status: http.StatusForbidden},
+		{name: "delete as viewer", method: http.MethodDelete, path: "/person/delete/1", token: "viewer-token", status: http.StatusForbidden},
+		{name: "get without token", method: http.MethodGet, path: "/person/1", status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if test.body != "" {
+				request.Header.Set("Content-Type", "application/json")
+			}
+
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d, body %q", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRateLimitIsKeyedOnVerifiedCaller(t *testing.T) {
+	router := newTestRouter(t, newTestHandler(t, ratelimit.Limit{Rate: 0.001, Burst: 1}))
+
+	// Viewers may not delete, so the policy rejects the requests right after the bucket is charged.
+	// Requests failing verification share the bucket of their client IP.
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "forged token", authorization: "Bearer forged", status: http.StatusUnauthorized},
+		{name: "first request of the viewer", authorization: "Bearer viewer-token", status: http.StatusForbidden},
+		{name: "second request of the viewer", authorization: "Bearer viewer-token", status: http.StatusTooManyRequests},
+		{name: "first request of another viewer", authorization: "Bearer dave-token", status: http.StatusForbidden},
+		{name: "forged token once the client IP is limited", authorization: "Bearer forged", status: http.StatusTooManyRequests},
+		{name: "missing token once the client IP is limited", status: http.StatusTooManyRequests},
+		{name: "another scheme once the client IP is limited", authorization: "Basic x", status: http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodDelete, "/person/delete/1", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d, body %q", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "no person matched", err: fmt.Errorf("person with ID 1: %w", repohandler.ErrNotFound), status: http.StatusNotFound},
+		{name: "deadline exceeded", err: fmt.Errorf("find: %w", context.DeadlineExceeded), status: http.StatusGatewayTimeout},
+		{name: "other error", err: errors.New("person with ID 1 not found in the cache"), status: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := errorStatus(test.err)
+			if status != test.status {
+				t.Errorf("errorStatus(%q) = %d, want %d", test.err, status, test.status)
+			}
+		})
+	}
+}
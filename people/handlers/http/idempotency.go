@@ -0,0 +1,152 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	repohandler "github.com/pavr1/people_project/people/handlers/repo"
+	"github.com/pavr1/people_project/people/models"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// recordingWriter passes the response through while keeping a copy to store for replays.
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(statusCode int) {
+	if rw.statusCode == 0 {
+		rw.statusCode = statusCode
+	}
+
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingWriter) Write(data []byte) (int, error) {
+	if rw.statusCode == 0 {
+		rw.statusCode = http.StatusOK
+	}
+
+	rw.body.Write(data)
+
+	return rw.ResponseWriter.Write(data)
+}
+
+// idempotent runs handle once per Idempotency-Key of the principal within the configured window.
+// Retries with the same key and payload get the stored response, a different payload gets a 422.
+// Server errors are not stored, so the request can be retried with the same key.
+func (h *HttpHandler) idempotent(w http.ResponseWriter, r *http.Request, principal *models.Principal, body []byte, handle func(w http.ResponseWriter)) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		handle(w)
+
+		return
+	}
+
+	if len(key) > maxIdempotencyKeyLength {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Idempotency-Key is too long"))
+
+		return
+	}
+
+	scope := principal.Tenant + "/" + principal.UserName
+	fingerprint := requestFingerprint(r, body)
+
+	record, lease, err := h.repo.ReserveIdempotencyKey(r.Context(), scope, key, fingerprint)
+	if errors.Is(err, repohandler.ErrContended) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	if record != nil {
+		h.replay(r.Context(), w, record, fingerprint)
+
+		return
+	}
+
+	rw := &recordingWriter{ResponseWriter: w}
+	handle(rw)
+
+	// The outcome is stored even when the caller stopped waiting for it
+	ctx := context.WithoutCancel(r.Context())
+
+	if rw.statusCode == 0 || rw.statusCode >= http.StatusInternalServerError {
+		err = h.repo.ReleaseIdempotencyKey(ctx, scope, key, lease)
+		if errors.Is(err, repohandler.ErrLeaseLost) {
+			h.logger(r.Context()).WithError(err).Warn("Idempotency key was taken over before it was released")
+		} else if err != nil {
+			h.logger(r.Context()).WithError(err).Error("Failed to release idempotency key")
+		}
+
+		return
+	}
+
+	err = h.repo.CompleteIdempotencyKey(ctx, scope, key, lease, rw.statusCode, rw.Header().Get("Content-Type"), rw.body.Bytes())
+	if errors.Is(err, repohandler.ErrLeaseLost) {
+		// The request taking the key over stores its own response
+		h.logger(r.Context()).WithError(err).Warn("Idempotency key was taken over, the response is not stored")
+	} else if err != nil {
+		h.logger(r.Context()).WithError(err).Error("Failed to store idempotent response")
+	}
+}
+
+func (h *HttpHandler) replay(ctx context.Context, w http.ResponseWriter, record *models.IdempotencyRecord, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		h.logger(ctx).WithField("key", record.Key).Warn("Idempotency-Key reused with a different request")
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte("Idempotency-Key was already used with a different request"))
+
+		return
+	}
+
+	if record.Status == 0 {
+		// The first request finishes or its lease runs out by then
+		retryAfter := time.Until(record.ReservedAt.Add(h.repo.Config.Idempotency.Lease))
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("A request with this Idempotency-Key is still in progress"))
+
+		return
+	}
+
+	h.logger(ctx).WithField("key", record.Key).Info("Replaying idempotent response")
+
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// requestFingerprint identifies the payload a key was first used with.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	_config "github.com/pavr1/people_project/people/config"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/middleware"
+	"github.com/pavr1/people_project/shared/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/pavr1/people_project/people/handlers/metrics")
+
+// Observation is a request in a batch, as the metrics service ingests it.
+type Observation struct {
+	Method          string  `json:"method"`
+	Route           string  `json:"route"`
+	Status          int     `json:"status"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	RequestID       string  `json:"request_id,omitempty"`
+}
+
+// Batch is the body of a push to the metrics service.
+type Batch struct {
+	Observations []Observation `json:"observations"`
+}
+
+// Pusher queues request observations and sends them to the metrics service in batches, for
+// environments that collect metrics there rather than by scraping /metrics. The queue is bounded,
+// when it is full observations are dropped by the drop policy and counted.
+type Pusher struct {
+	log        *log.Logger
+	url        string
+	token      string
+	client     *http.Client
+	batchSize  int
+	interval   time.Duration
+	dropOldest bool
+
+	mu    sync.Mutex
+	queue []Observation
+	head  int
+	count int
+	full  chan struct{}
+
+	dropped *prometheus.CounterVec
+	batches *prometheus.CounterVec
+	length  prometheus.GaugeFunc
+}
+
+// NewPusher sends batches to metrics.url with client, which applies the metrics timeout and
+// policy, and registers the queue metrics with registerer.
+func NewPusher(log *log.Logger, config *_config.Config, client *http.Client, registerer prometheus.Registerer) (*Pusher, error) {
+	p := &Pusher{
+		log:        log,
+		url:        config.Metrics.Url,
+		token:      config.Metrics.Token.Reveal(),
+		client:     client,
+		batchSize:  config.Metrics.BatchSize,
+		interval:   config.Metrics.FlushInterval,
+		dropOldest: config.Metrics.DropPolicy == _config.DropPolicyOldest,
+		queue:      make([]Observation, config.Metrics.QueueSize),
+		full:       make(chan struct{}, 1),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "people_metrics_push_dropped_total",
+			Help: "Observations not pushed by reason: queue_full or send_failed.",
+		}, []string{"reason"}),
+		batches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "people_metrics_push_batches_total",
+			Help: "Batches pushed to the metrics service by result.",
+		}, []string{"result"}),
+	}
+
+	p.length = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "people_metrics_push_queue_length",
+		Help: "Observations waiting to be pushed.",
+	}, func() float64 {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		return float64(p.count)
+	})
+
+	for _, collector := range []prometheus.Collector{p.dropped, p.batches, p.length} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Observe is the middleware.Observer queueing the observation without waiting for the metrics
+// service.
+func (p *Pusher) Observe(r *http.Request, observation middleware.Observation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.count == len(p.queue) {
+		p.dropped.WithLabelValues("queue_full").Inc()
+		if !p.dropOldest {
+			return
+		}
+
+		p.head = (p.head + 1) % len(p.queue)
+		p.count--
+	}
+
+	p.queue[(p.head+p.count)%len(p.queue)] = Observation{
+		Method:          observation.Method,
+		Route:           observation.Route,
+		Status:          observation.Status,
+		DurationSeconds: observation.Duration.Seconds(),
+		RequestID:       logging.RequestID(r.Context()),
+	}
+	p.count++
+
+	// A full batch is sent right away rather than on the next tick
+	if p.count >= p.batchSize {
+		select {
+		case p.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run sends a batch every flush interval and whenever one is full, until ctx is done. Flush
+// sends what is left.
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.full:
+		}
+
+		p.send(ctx, p.take())
+	}
+}
+
+// Flush sends every queued observation, e.g. on shutdown once no more requests are served.
+func (p *Pusher) Flush(ctx context.Context) {
+	for {
+		batch := p.take()
+		if len(batch) == 0 {
+			return
+		}
+
+		p.send(ctx, batch)
+	}
+}
+
+// take removes up to a batch of observations from the queue.
+func (p *Pusher) take() []Observation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	batch := make([]Observation, 0, min(p.count, p.batchSize))
+	for len(batch) < p.batchSize && p.count > 0 {
+		batch = append(batch, p.queue[p.head])
+		p.head = (p.head + 1) % len(p.queue)
+		p.count--
+	}
+
+	return batch
+}
+
+func (p *Pusher) send(ctx context.Context, batch []Observation) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// A batch spans many requests, its push starts a trace of its own
+	ctx, span := tracer.Start(ctx, "Pusher.push", trace.WithNewRoot(), trace.WithAttributes(attribute.Int("metrics.observations", len(batch))))
+	defer span.End()
+
+	err := tracing.RecordError(span, p.post(ctx, batch))
+	if err != nil {
+		p.log.WithError(err).WithField("observations", len(batch)).Error("Failed to push metrics")
+		p.batches.WithLabelValues("failure").Inc()
+		p.dropped.WithLabelValues("send_failed").Add(float64(len(batch)))
+
+		return
+	}
+
+	p.batches.WithLabelValues("success").Inc()
+}
+
+func (p *Pusher) post(ctx context.Context, batch []Observation) error {
+	body, err := json.Marshal(Batch{Observations: batch})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("metrics service returned %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package policy
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pavr1/people_project/people/config"
+)
+
+//go:embed policy.json
+var defaultPolicy []byte
+
+// Rule grants access to a route, identified by its mux path template and method, to a set of roles.
+type Rule struct {
+	Path   string   `json:"path"`
+	Method string   `json:"method"`
+	Roles  []string `json:"roles"`
+}
+
+type Policy struct {
+	log   *log.Logger
+	rules map[string][]string
+}
+
+// NewPolicy loads the policy file from config, or the embedded default policy when none is set.
+func NewPolicy(log *log.Logger, config *config.Config) (*Policy, error) {
+	data := defaultPolicy
+
+	if config.Policy.File != "" {
+		fileData, err := os.ReadFile(config.Policy.File)
+		if err != nil {
+			log.WithError(err).Error("Failed to read policy file")
+
+			return nil, err
+		}
+
+		data = fileData
+	}
+
+	file := struct {
+		Rules []Rule `json:"rules"`
+	}{}
+
+	err := json.Unmarshal(data, &file)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse policy file")
+
+		return nil, err
+	}
+
+	rules := map[string][]string{}
+	for _, rule := range file.Rules {
+		if rule.Path == "" || rule.Method == "" {
+			return nil, fmt.Errorf("policy rule %v needs a path and a method", rule)
+		}
+
+		key := ruleKey(rule.Path, rule.Method)
+		rules[key] = append(rules[key], rule.Roles...)
+	}
+
+	log.WithField("rules", len(file.Rules)).Info("Loaded access policy")
+
+	return &Policy{
+		log:   log,
+		rules: rules,
+	}, nil
+}
+
+// IsAllowed reports whether any of the roles may call the route. Routes without a rule are denied.
+func (p *Policy) IsAllowed(path string, method string, roles []string) bool {
+	for _, allowed := range p.rules[ruleKey(path, method)] {
+		for _, role := range roles {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func ruleKey(path string, method string) string {
+	return method + " " + path
+}
@@ -0,0 +1,178 @@
+package repo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pavr1/people_project/people/config"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errMissingRole = errors.New("missing MongoDB role")
+
+// connectToMongoDB retries until MongoDB answers, waiting an exponentially growing, jittered
+// backoff between attempts so replicas starting together do not retry in lockstep.
+func connectToMongoDB(log *log.Logger, config *config.Config, monitor *event.CommandMonitor) (*mongo.Client, error) {
+	clientOptions, err := clientOptions(config)
+	if err != nil {
+		log.WithError(err).Error("Invalid MongoDB options")
+
+		return nil, err
+	}
+
+	clientOptions.SetMonitor(monitor)
+
+	log.WithField("uri", redactedUri(config.MongoDB.Uri)).Info("Connecting to MongoDB...")
+
+	client, err := mongo.Connect(context.Background(), clientOptions)
+	if err != nil {
+		log.WithError(err).Error("Failed to connect to MongoDB")
+
+		return nil, err
+	}
+
+	backoff := config.MongoDB.Connect.Backoff
+	for attempt := 1; ; attempt++ {
+		err = pingWithRole(client, config)
+		if err == nil {
+			break
+		}
+
+		// Waiting does not grant a missing role
+		if attempt == config.MongoDB.Connect.Attempts || errors.Is(err, errMissingRole) {
+			log.WithError(err).WithField("attempts", attempt).Error("Failed to ping MongoDB")
+			client.Disconnect(context.Background())
+
+			return nil, err
+		}
+
+		// Anywhere between half and all of the backoff
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.WithError(err).WithField("attempt", attempt).WithField("retry_in", wait.String()).Warn("MongoDB is not reachable yet")
+		time.Sleep(wait)
+
+		backoff = min(backoff*2, config.MongoDB.Connect.MaxBackoff)
+	}
+
+	log.Info("Connected to MongoDB")
+
+	return client, nil
+}
+
+func clientOptions(config *config.Config) (*options.ClientOptions, error) {
+	clientOptions := options.Client().ApplyURI(config.MongoDB.Uri)
+
+	if config.MongoDB.Username != "" || config.MongoDB.AuthMechanism != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username:      config.MongoDB.Username,
+			Password:      config.MongoDB.Password.Reveal(),
+			PasswordSet:   !config.MongoDB.Password.IsZero(),
+			AuthSource:    config.MongoDB.AuthSource,
+			AuthMechanism: config.MongoDB.AuthMechanism,
+		})
+	}
+
+	if config.MongoDB.TLS.Enabled {
+		tlsConfig, err := tlsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	clientOptions.SetMaxPoolSize(config.MongoDB.Pool.MaxSize)
+	clientOptions.SetMinPoolSize(config.MongoDB.Pool.MinSize)
+	if config.MongoDB.Pool.MaxIdleTime > 0 {
+		clientOptions.SetMaxConnIdleTime(config.MongoDB.Pool.MaxIdleTime)
+	}
+
+	clientOptions.SetServerSelectionTimeout(config.MongoDB.Connect.Timeout)
+
+	return clientOptions, clientOptions.Validate()
+}
+
+func tlsConfig(config *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.MongoDB.TLS.Insecure,
+	}
+
+	if config.MongoDB.TLS.CAFile != "" {
+		pem, err := os.ReadFile(config.MongoDB.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.MongoDB.TLS.CAFile)
+		}
+	}
+
+	if config.MongoDB.TLS.CertificateKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.MongoDB.TLS.CertificateKeyFile, config.MongoDB.TLS.CertificateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// pingWithRole checks that MongoDB answers and, when a role is configured, that the connected
+// user was granted it, so a misconfigured user fails at startup instead of on the first write.
+func pingWithRole(client *mongo.Client, config *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.MongoDB.Connect.Timeout)
+	defer cancel()
+
+	err := client.Ping(ctx, nil)
+	if err != nil || config.MongoDB.Role == "" {
+		return err
+	}
+
+	status := struct {
+		AuthInfo struct {
+			Roles []struct {
+				Role string `bson:"role"`
+				DB   string `bson:"db"`
+			} `bson:"authenticatedUserRoles"`
+		} `bson:"authInfo"`
+	}{}
+
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "connectionStatus", Value: 1}}).Decode(&status)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range status.AuthInfo.Roles {
+		if role.Role == config.MongoDB.Role && role.DB == config.MongoDB.Database {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: user %q does not have role %s on database %s", errMissingRole, config.MongoDB.Username, config.MongoDB.Role, config.MongoDB.Database)
+}
+
+// redactedUri hides the password a MongoDB URI may carry, for logging.
+func redactedUri(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "invalid URI"
+	}
+
+	return parsed.Redacted()
+}
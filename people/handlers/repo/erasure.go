@@ -0,0 +1,308 @@
+package repo
+
+import (
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"time"
+
+	"github.com/pavr1/people_project/people/models"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxReceiptAttempts bounds the retries when concurrent erasures race for the next sequence.
+const maxReceiptAttempts = 5
+
+// erasureKey keys the subject hashes and the receipt chain.
+func (r *RepoHandler) erasureKey() []byte {
+	return []byte(r.Config.Erasure.Key.Reveal())
+}
+
+func (r *RepoHandler) erasureCollection() *mongo.Collection {
+	return r.client.Database(r.Config.MongoDB.Database).Collection(r.Config.Erasure.Collection)
+}
+
+// ensureErasureIndexes allows one receipt per sequence of a tenant, so the chain never forks, and
+// one pending receipt per subject, so concurrent erasures of a person record it once.
+func (r *RepoHandler) ensureErasureIndexes() error {
+	_, err := r.erasureCollection().Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "sequence", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "subjectHash", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": models.ErasurePending}),
+		},
+	})
+	if err != nil {
+		r.log.WithError(err).Error("Failed to create erasure indexes in MongoDB")
+
+		return err
+	}
+
+	return nil
+}
+
+// ExportPerson returns the stored document of the person if the principal may read it, nil otherwise.
+func (r *RepoHandler) ExportPerson(ctx context.Context, principal *models.Principal, id string) (*models.SubjectExport, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	collection := r.collection(principal.Tenant)
+
+	record := bson.M{}
+	err := collection.FindOne(ctx, r.readFilter(principal, bson.M{"id": id})).Decode(&record)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		r.logger(ctx).WithError(err).Error("Failed to find document in MongoDB")
+
+		return nil, err
+	}
+
+	err = r.encryptor.Open(record)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to decrypt document from MongoDB")
+
+		return nil, err
+	}
+
+	return &models.SubjectExport{
+		ExportedAt: time.Now().UTC(),
+		Tenant:     principal.Tenant,
+		Record:     record,
+	}, nil
+}
+
+// ErasePerson hard deletes the person and records it in the tenant's erasure chain. The receipt
+// is appended as pending before the delete and completed after it; erasing the person again
+// completes a receipt a failed attempt left pending instead of appending another one.
+func (r *RepoHandler) ErasePerson(ctx context.Context, principal *models.Principal, id string) (*models.ErasureReceipt, error) {
+	collection := r.collection(principal.Tenant)
+	filter := r.writeFilter(principal, bson.M{"id": id})
+	subjectHash := models.SubjectHash(r.erasureKey(), principal.Tenant, id)
+
+	receipt, err := r.pendingReceipt(ctx, principal.Tenant, subjectHash)
+	if err != nil {
+		return nil, err
+	}
+
+	resumed := receipt != nil
+
+	if !resumed {
+		receipt, resumed, err = r.appendPendingReceipt(ctx, principal, id, subjectHash, filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The receipt is pending, the person is deleted and the receipt completed even if the caller
+	// gives up
+	ctx = context.WithoutCancel(ctx)
+
+	deleteCtx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	result, err := collection.DeleteMany(deleteCtx, filter)
+	if err != nil {
+		r.logger(ctx).WithError(err).WithField("subject", subjectHash).Error("Failed to erase document from MongoDB, the receipt stays pending")
+
+		return nil, err
+	}
+
+	// Only a principal that may erase the person completes the receipt of an earlier attempt
+	if resumed && result.DeletedCount == 0 {
+		found, err := r.findPerson(ctx, principal.Tenant, r.tenantFilter(principal.Tenant, bson.M{"id": id}))
+		if err != nil {
+			return nil, err
+		}
+
+		if found != nil {
+			return nil, fmt.Errorf("person with ID %s: %w", id, ErrNotFound)
+		}
+	}
+
+	err = r.completeReceipt(ctx, receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger(ctx).WithFields(log.Fields{"subject": subjectHash, "resumed": resumed}).Info("Person erased successfully")
+
+	return receipt, nil
+}
+
+// pendingReceipt returns the receipt an earlier attempt to erase the subject left pending, if any.
+func (r *RepoHandler) pendingReceipt(ctx context.Context, tenant string, subjectHash string) (*models.ErasureReceipt, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	receipt := models.ErasureReceipt{}
+	err := r.erasureCollection().FindOne(ctx, bson.M{"tenant": tenant, "subjectHash": subjectHash, "status": models.ErasurePending}).Decode(&receipt)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to find pending erasure receipt in MongoDB")
+
+		return nil, err
+	}
+
+	return &receipt, nil
+}
+
+// appendPendingReceipt counts the documents of the person filter erases and appends a pending
+// receipt for them. When a concurrent erasure of the subject appended one first, that receipt is
+// returned as resumed instead.
+func (r *RepoHandler) appendPendingReceipt(ctx context.Context, principal *models.Principal, id string, subjectHash string, filter bson.M) (*models.ErasureReceipt, bool, error) {
+	countCtx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	records, err := r.collection(principal.Tenant).CountDocuments(countCtx, filter)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to count documents to erase in MongoDB")
+
+		return nil, false, err
+	}
+
+	if records == 0 {
+		return nil, false, fmt.Errorf("person with ID %s: %w", id, ErrNotFound)
+	}
+
+	receipt := models.ErasureReceipt{
+		Tenant:      principal.Tenant,
+		SubjectHash: subjectHash,
+		ErasedBy:    principal.UserName,
+		ErasedAt:    time.Now().UTC().Truncate(time.Millisecond),
+		Records:     records,
+		Status:      models.ErasurePending,
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = r.appendReceipt(ctx, &receipt)
+		if err == nil {
+			return &receipt, false, nil
+		}
+
+		if mongo.IsDuplicateKeyError(err) {
+			// Either another receipt took the sequence or another erasure of the subject is pending
+			pending, err := r.pendingReceipt(ctx, principal.Tenant, subjectHash)
+			if err != nil {
+				return nil, false, err
+			}
+
+			if pending != nil {
+				return pending, true, nil
+			}
+		}
+
+		if !mongo.IsDuplicateKeyError(err) || attempt == maxReceiptAttempts {
+			// Nothing was deleted yet, the erasure can be retried
+			r.logger(ctx).WithError(err).WithField("subject", subjectHash).Error("Failed to store erasure receipt")
+
+			return nil, false, err
+		}
+	}
+}
+
+// completeReceipt marks the receipt completed once the person is gone.
+func (r *RepoHandler) completeReceipt(ctx context.Context, receipt *models.ErasureReceipt) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	filter := bson.M{"tenant": receipt.Tenant, "sequence": receipt.Sequence}
+	_, err := r.erasureCollection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": models.ErasureCompleted}})
+	if err != nil {
+		// The person is gone, erasing it again completes the receipt
+		r.logger(ctx).WithError(err).WithField("subject", receipt.SubjectHash).Error("Failed to complete erasure receipt")
+
+		return err
+	}
+
+	receipt.Status = models.ErasureCompleted
+
+	return nil
+}
+
+// appendReceipt links the receipt to the last one of its tenant and stores it.
+func (r *RepoHandler) appendReceipt(ctx context.Context, receipt *models.ErasureReceipt) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	collection := r.erasureCollection()
+
+	receipt.Sequence = 1
+	receipt.PreviousHash = ""
+
+	last := models.ErasureReceipt{}
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "sequence", Value: -1}})
+	err := collection.FindOne(ctx, bson.M{"tenant": receipt.Tenant}, findOptions).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	if err == nil {
+		receipt.Sequence = last.Sequence + 1
+		receipt.PreviousHash = last.Hash
+	}
+
+	receipt.Hash = receipt.ComputeHash(r.erasureKey())
+
+	_, err = collection.InsertOne(ctx, receipt)
+
+	return err
+}
+
+// GetErasureLog returns the tenant's receipts in order and verifies their chain.
+func (r *RepoHandler) GetErasureLog(ctx context.Context, tenant string) (*models.ErasureLog, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}})
+
+	cur, err := r.erasureCollection().Find(ctx, bson.M{"tenant": tenant}, findOptions)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to find erasure receipts in MongoDB")
+
+		return nil, err
+	}
+
+	erasureLog := models.ErasureLog{Receipts: []models.ErasureReceipt{}, Valid: true}
+
+	err = cur.All(ctx, &erasureLog.Receipts)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to decode erasure receipts")
+
+		return nil, err
+	}
+
+	previousHash := ""
+	for i, receipt := range erasureLog.Receipts {
+		switch {
+		case receipt.Sequence != int64(i+1):
+			erasureLog.Problem = fmt.Sprintf("receipt %d is missing", i+1)
+		case receipt.PreviousHash != previousHash:
+			erasureLog.Problem = fmt.Sprintf("receipt %d does not follow receipt %d", receipt.Sequence, i)
+		case !hmac.Equal([]byte(receipt.Hash), []byte(receipt.ComputeHash(r.erasureKey()))):
+			erasureLog.Problem = fmt.Sprintf("receipt %d was modified", receipt.Sequence)
+		}
+
+		if erasureLog.Problem != "" {
+			erasureLog.Valid = false
+
+			break
+		}
+
+		previousHash = receipt.Hash
+	}
+
+	return &erasureLog, nil
+}
@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pavr1/people_project/people/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (r *RepoHandler) idempotencyCollection() *mongo.Collection {
+	return r.client.Database(r.Config.MongoDB.Database).Collection(r.Config.Idempotency.Collection)
+}
+
+// ensureIdempotencyIndexes makes keys unique per scope and lets MongoDB drop expired records.
+// Expiry is stored on each record, so changing the window never conflicts with the TTL index.
+func (r *RepoHandler) ensureIdempotencyIndexes() error {
+	_, err := r.idempotencyCollection().Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "scope", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		r.log.WithError(err).Error("Failed to create idempotency indexes in MongoDB")
+
+		return err
+	}
+
+	return nil
+}
+
+var (
+	// ErrContended is returned while other requests keep taking over the same key.
+	ErrContended = errors.New("idempotency key is contended, retry later")
+	// ErrLeaseLost is returned when the lease of a reservation ran out and another request took
+	// the key over, the response of the first request is not stored.
+	ErrLeaseLost = errors.New("idempotency lease lost")
+)
+
+// reserveAttempts bounds the retries of a reservation racing with expiry and other requests.
+const reserveAttempts = 3
+
+// ReserveIdempotencyKey claims the key for a new request and returns the lease to complete or
+// release it with, or returns the record of the earlier request that already holds it. Expired
+// records and reservations whose lease ran out, e.g. because the pod handling them died, are
+// replaced.
+func (r *RepoHandler) ReserveIdempotencyKey(ctx context.Context, scope string, key string, fingerprint string) (*models.IdempotencyRecord, string, error) {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	collection := r.idempotencyCollection()
+
+	lease, err := newLease()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for attempt := 0; attempt < reserveAttempts; attempt++ {
+		now := time.Now()
+
+		record := models.IdempotencyRecord{
+			Scope:       scope,
+			Key:         key,
+			Fingerprint: fingerprint,
+			ReservedAt:  now,
+			Lease:       lease,
+			ExpiresAt:   now.Add(r.Config.Idempotency.Window),
+		}
+
+		_, err := collection.InsertOne(ctx, record)
+		if err == nil {
+			return nil, lease, nil
+		}
+
+		if !mongo.IsDuplicateKeyError(err) {
+			r.logger(ctx).WithError(err).Error("Failed to reserve idempotency key in MongoDB")
+
+			return nil, "", err
+		}
+
+		existing := models.IdempotencyRecord{}
+		err = collection.FindOne(ctx, bson.M{"scope": scope, "key": key}).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			// Expired and removed in between, try again
+			continue
+		}
+
+		if err != nil {
+			r.logger(ctx).WithError(err).Error("Failed to find idempotency key in MongoDB")
+
+			return nil, "", err
+		}
+
+		// The TTL monitor only runs once a minute, expired records may still be around
+		expired := existing.ExpiresAt.Before(now)
+		abandoned := existing.Status == 0 && existing.ReservedAt.Before(now.Add(-r.Config.Idempotency.Lease))
+		if !expired && !abandoned {
+			return &existing, "", nil
+		}
+
+		if abandoned {
+			r.logger(ctx).WithField("key", key).Warn("Taking over abandoned idempotency key")
+		}
+
+		// Only the record just read is deleted, a request taking it over first wins
+		_, err = collection.DeleteOne(ctx, bson.M{"scope": scope, "key": key, "status": existing.Status, "expiresAt": existing.ExpiresAt})
+		if err != nil {
+			r.logger(ctx).WithError(err).Error("Failed to delete expired idempotency key from MongoDB")
+
+			return nil, "", err
+		}
+	}
+
+	return nil, "", fmt.Errorf("idempotency key %s: %w", key, ErrContended)
+}
+
+// newLease returns a random lease token.
+func newLease() (string, error) {
+	lease := make([]byte, 16)
+
+	_, err := rand.Read(lease)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(lease), nil
+}
+
+// CompleteIdempotencyKey stores the response to replay for the key reserved with lease.
+func (r *RepoHandler) CompleteIdempotencyKey(ctx context.Context, scope string, key string, lease string, status int, contentType string, body []byte) error {
+	update := bson.M{"$set": bson.M{
+		"status":      status,
+		"contentType": contentType,
+		"body":        body,
+	}}
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	result, err := r.idempotencyCollection().UpdateOne(ctx, bson.M{"scope": scope, "key": key, "status": 0, "lease": lease}, update)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to complete idempotency key in MongoDB")
+
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("idempotency key %s: %w", key, ErrLeaseLost)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets the key reserved with lease so the request can be retried.
+func (r *RepoHandler) ReleaseIdempotencyKey(ctx context.Context, scope string, key string, lease string) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	result, err := r.idempotencyCollection().DeleteOne(ctx, bson.M{"scope": scope, "key": key, "status": 0, "lease": lease})
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to release idempotency key in MongoDB")
+
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("idempotency key %s: %w", key, ErrLeaseLost)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyLeaseTakeover(t *testing.T) {
+	uri := os.Getenv("PEOPLE_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("PEOPLE_TEST_MONGODB_URI is not set")
+	}
+
+	r := newTestRepoHandler(t, uri)
+	r.Config.Idempotency.Window = time.Hour
+	r.Config.Idempotency.Lease = time.Millisecond
+
+	ctx := context.Background()
+
+	_, first, err := r.ReserveIdempotencyKey(ctx, "acme/alice", "key", "fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// The first request outlived its lease, a retry takes the key over
+	record, second, err := r.ReserveIdempotencyKey(ctx, "acme/alice", "key", "fingerprint")
+	if err != nil || record != nil || second == "" || second == first {
+		t.Fatalf("takeover = %v, %q, %v, want a new lease", record, second, err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		err  error
+	}{
+		{name: "first request completes", call: func() error { return r.CompleteIdempotencyKey(ctx, "acme/alice", "key", first, 200, "", nil) }, err: ErrLeaseLost},
+		{name: "first request releases", call: func() error { return r.ReleaseIdempotencyKey(ctx, "acme/alice", "key", first) }, err: ErrLeaseLost},
+		{name: "second request completes", call: func() error { return r.CompleteIdempotencyKey(ctx, "acme/alice", "key", second, 201, "", nil) }},
+		{name: "second request releases a completed key", call: func() error { return r.ReleaseIdempotencyKey(ctx, "acme/alice", "key", second) }, err: ErrLeaseLost},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call()
+			if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+				t.Errorf("error = %v, want %v", err, test.err)
+			}
+		})
+	}
+
+	record, _, err = r.ReserveIdempotencyKey(ctx, "acme/alice", "key", "fingerprint")
+	if err != nil || record == nil || record.Status != 201 {
+		t.Errorf("replayed record = %+v, %v, want the response of the second request", record, err)
+	}
+}
@@ -0,0 +1,225 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/pavr1/people_project/people/models"
+)
+
+// MemoryStats is a StatsStore over people kept in the process. It gives the same results as the
+// MongoDB aggregation for the same people.
+type MemoryStats struct {
+	mu      sync.RWMutex
+	people  map[string][]models.Person
+	indexed map[string]bool
+}
+
+// NewMemoryStats returns an empty store that may group by the indexed fields.
+func NewMemoryStats(indexed ...string) *MemoryStats {
+	m := &MemoryStats{
+		people:  map[string][]models.Person{},
+		indexed: map[string]bool{},
+	}
+
+	for _, field := range indexed {
+		m.indexed[field] = true
+	}
+
+	return m
+}
+
+// Add stores a person of the tenant, with its owner and grants.
+func (m *MemoryStats) Add(tenant string, person models.Person) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.people[tenant] = append(m.people[tenant], person)
+}
+
+func (m *MemoryStats) GetPersonStats(ctx context.Context, principal *models.Principal, statsOptions models.StatsOptions) (*models.PersonStats, error) {
+	if statsOptions.GroupBy != "" && !m.indexed[statsOptions.GroupBy] {
+		return nil, fmt.Errorf("group by field %s is %w", statsOptions.GroupBy, ErrNotIndexed)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	people := []models.Person{}
+	for _, person := range m.people[principal.Tenant] {
+		if canRead(principal, person) && matches(person, statsOptions.Filter) {
+			people = append(people, person)
+		}
+	}
+
+	stats := models.PersonStats{
+		Total:        int64(len(people)),
+		TopLastNames: []models.NameCount{},
+	}
+
+	ages := make([]int32, 0, len(people))
+	for _, person := range people {
+		ages = append(ages, person.Age)
+	}
+
+	slices.Sort(ages)
+
+	if len(ages) > 0 {
+		stats.Age.Min = ages[0]
+		stats.Age.Max = ages[len(ages)-1]
+		stats.Age.Mean = mean(ages)
+
+		middle := ages[(len(ages)-1)/2 : len(ages)/2+1]
+		stats.Age.Median = mean(middle)
+	}
+
+	stats.Age.Histogram = []models.AgeBucket{}
+	for i := 0; i < len(statsOptions.AgeBuckets)-1; i++ {
+		stats.Age.Histogram = append(stats.Age.Histogram, models.AgeBucket{
+			From: statsOptions.AgeBuckets[i],
+			To:   statsOptions.AgeBuckets[i+1],
+		})
+	}
+
+	for _, age := range ages {
+		bucket := sort.Search(len(stats.Age.Histogram), func(i int) bool {
+			return age < stats.Age.Histogram[i].To
+		})
+
+		if bucket == len(stats.Age.Histogram) || age < stats.Age.Histogram[bucket].From {
+			stats.Age.OutOfRange++
+
+			continue
+		}
+
+		stats.Age.Histogram[bucket].Count++
+	}
+
+	lastNames := map[string]int64{}
+	for _, person := range people {
+		lastNames[person.LastName]++
+	}
+
+	for lastName, count := range lastNames {
+		stats.TopLastNames = append(stats.TopLastNames, models.NameCount{LastName: lastName, Count: count})
+	}
+
+	sort.Slice(stats.TopLastNames, func(i, j int) bool {
+		if stats.TopLastNames[i].Count != stats.TopLastNames[j].Count {
+			return stats.TopLastNames[i].Count > stats.TopLastNames[j].Count
+		}
+
+		return stats.TopLastNames[i].LastName < stats.TopLastNames[j].LastName
+	})
+
+	if statsOptions.Top > 0 && int64(len(stats.TopLastNames)) > statsOptions.Top {
+		stats.TopLastNames = stats.TopLastNames[:statsOptions.Top]
+	}
+
+	if statsOptions.GroupBy != "" {
+		stats.Groups = groupStats(people, statsOptions.GroupBy)
+	}
+
+	return &stats, nil
+}
+
+// groupStats breaks the ages down by the value of field, sorted by that value.
+func groupStats(people []models.Person, field string) []models.GroupStats {
+	groups := map[interface{}][]int32{}
+	for _, person := range people {
+		key := personField(person, field)
+		groups[key] = append(groups[key], person.Age)
+	}
+
+	stats := []models.GroupStats{}
+	for key, ages := range groups {
+		slices.Sort(ages)
+
+		stats = append(stats, models.GroupStats{
+			Key:     key,
+			Total:   int64(len(ages)),
+			MinAge:  ages[0],
+			MaxAge:  ages[len(ages)-1],
+			MeanAge: mean(ages),
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return lessKey(stats[i].Key, stats[j].Key)
+	})
+
+	return stats
+}
+
+// personField returns the stored value of field the way MongoDB decodes it, nil for a field people
+// do not have.
+func personField(person models.Person, field string) interface{} {
+	values := map[string]interface{}{
+		"id":       person.ID,
+		"name":     person.Name,
+		"lastName": person.LastName,
+		"age":      person.Age,
+		"owner":    person.Owner,
+	}
+
+	return values[field]
+}
+
+// lessKey orders group keys like MongoDB sorts them: missing values first, numbers before strings.
+func lessKey(a interface{}, b interface{}) bool {
+	rank := func(value interface{}) int {
+		switch value.(type) {
+		case nil:
+			return 0
+		case int32:
+			return 1
+		}
+
+		return 2
+	}
+
+	if rank(a) != rank(b) {
+		return rank(a) < rank(b)
+	}
+
+	switch a := a.(type) {
+	case int32:
+		return a < b.(int32)
+	case string:
+		return a < b.(string)
+	}
+
+	return false
+}
+
+// canRead applies the read filter of RepoHandler to a person of the principal's tenant.
+func canRead(principal *models.Principal, person models.Person) bool {
+	if principal.IsAdmin || person.Owner == principal.UserName {
+		return true
+	}
+
+	for _, grantee := range principal.Grantees() {
+		if slices.Contains(person.Readers, grantee) || slices.Contains(person.Writers, grantee) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches applies the exact matches of the person filter.
+func matches(person models.Person, filter models.PersonFilter) bool {
+	return (filter.Name == "" || person.Name == filter.Name) && (filter.LastName == "" || person.LastName == filter.LastName)
+}
+
+func mean(ages []int32) float64 {
+	sum := 0.0
+	for _, age := range ages {
+		sum += float64(age)
+	}
+
+	return sum / float64(len(ages))
+}
@@ -0,0 +1,40 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+)
+
+// commandMonitor times every MongoDB command the repository sends and records it as a client
+// span of the request. Collections are not a label, in the collection tenant mode there is one
+// per tenant.
+func commandMonitor(registerer prometheus.Registerer) (*event.CommandMonitor, error) {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "people_repository_command_duration_seconds",
+		Help:    "Duration of the MongoDB commands of the repository by command and result.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"command", "result"})
+
+	err := registerer.Register(duration)
+	if err != nil {
+		return nil, err
+	}
+
+	// The commands hold encrypted personal data, spans leave them out
+	spans := otelmongo.NewMonitor(otelmongo.WithCommandAttributeDisabled(true))
+
+	return &event.CommandMonitor{
+		Started: spans.Started,
+		Succeeded: func(ctx context.Context, succeeded *event.CommandSucceededEvent) {
+			duration.WithLabelValues(succeeded.CommandName, "success").Observe(succeeded.Duration.Seconds())
+			spans.Succeeded(ctx, succeeded)
+		},
+		Failed: func(ctx context.Context, failed *event.CommandFailedEvent) {
+			duration.WithLabelValues(failed.CommandName, "failure").Observe(failed.Duration.Seconds())
+			spans.Failed(ctx, failed)
+		},
+	}, nil
+}
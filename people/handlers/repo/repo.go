@@ -2,104 +2,291 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"time"
+	"sync"
 
 	"github.com/pavr1/people_project/people/config"
+	"github.com/pavr1/people_project/people/handlers/encryption"
 	"github.com/pavr1/people_project/people/models"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrNotFound is returned by writes matching no person the principal may write, a person that
+// does not exist is not told apart from one the principal may not see.
+var ErrNotFound = errors.New("person not found")
+
 type RepoHandler struct {
-	log    *log.Logger
-	Config *config.Config
-	client *mongo.Client
+	log       *log.Logger
+	Config    *config.Config
+	client    *mongo.Client
+	encryptor *encryption.Encryptor
+	// indexed holds the collections whose person indexes were ensured
+	indexed sync.Map
 }
 
-func NewRepoHandler(log *log.Logger, config *config.Config) (*RepoHandler, error) {
-	client, err := connectToMongoDB(config)
+// NewRepoHandler connects to MongoDB, registering the command timings with registerer.
+func NewRepoHandler(log *log.Logger, config *config.Config, registerer prometheus.Registerer) (*RepoHandler, error) {
+	encryptor, err := encryption.NewEncryptor(log, config)
 	if err != nil {
-		log.WithField("error", err).Error("Failed to connect to MongoDB")
+		log.WithError(err).Error("Failed to create encryptor")
 
 		return nil, err
 	}
 
-	return &RepoHandler{
-		log:    log,
-		Config: config,
-		client: client,
-	}, nil
-}
-
-func connectToMongoDB(config *config.Config) (*mongo.Client, error) {
-	uri := config.MongoDB.Uri
+	monitor, err := commandMonitor(registerer)
+	if err != nil {
+		log.WithError(err).Error("Failed to register repository metrics")
 
-	log.WithField("uri", uri).Info("Connecting to MongoDB...")
+		return nil, err
+	}
 
-	clientOptions := options.Client().ApplyURI(uri)
-	// clientOptions.SetAuth(options.Credential{
-	// 	Username: config.MongoDB.Username,
-	// 	Password: config.MongoDB.Password,
-	// })
-	client, err := mongo.Connect(context.TODO(), clientOptions)
+	client, err := connectToMongoDB(log, config, monitor)
 	if err != nil {
 		log.WithError(err).Error("Failed to connect to MongoDB")
 
 		return nil, err
 	}
 
-	// Check the connection
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	repoHandler := &RepoHandler{
+		log:       log,
+		Config:    config,
+		client:    client,
+		encryptor: encryptor,
+	}
+
+	// a collection of its own is indexed once the tenant creates a person
+	if repoHandler.sharedCollection() {
+		err = repoHandler.ensurePersonIndexes(context.Background(), "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = repoHandler.ensureIdempotencyIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = repoHandler.ensureErasureIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	return repoHandler, nil
+}
+
+// Ping checks that MongoDB answers.
+func (r *RepoHandler) Ping(ctx context.Context) error {
+	ctx, cancel := r.readContext(ctx)
 	defer cancel()
 
-	err = client.Ping(ctx, nil)
+	return r.client.Ping(ctx, nil)
+}
+
+// Close disconnects from MongoDB once the server stopped serving.
+func (r *RepoHandler) Close(ctx context.Context) error {
+	err := r.client.Disconnect(ctx)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to disconnect from MongoDB")
+
+		return err
+	}
+
+	r.logger(ctx).Info("Disconnected from MongoDB")
+
+	return nil
+}
+
+// logger is the logger of the request ctx belongs to, carrying its request ID.
+func (r *RepoHandler) logger(ctx context.Context) *log.Entry {
+	return logging.FromContext(ctx, r.log)
+}
+
+// readContext bounds a query by the configured read timeout.
+func (r *RepoHandler) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, r.Config.Timeouts.Read)
+}
+
+// writeContext bounds an insert, update or delete by the configured write timeout.
+func (r *RepoHandler) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, r.Config.Timeouts.Write)
+}
+
+// collection returns the collection holding the tenant's people.
+func (r *RepoHandler) collection(tenant string) *mongo.Collection {
+	name := r.Config.MongoDB.Collection
+	if r.Config.MongoDB.TenantMode == config.TenantModeCollection {
+		name = name + "_" + tenant
+	}
+
+	return r.client.Database(r.Config.MongoDB.Database).Collection(name)
+}
+
+// sharedCollection tells whether the people of every tenant are stored in one collection.
+func (r *RepoHandler) sharedCollection() bool {
+	return r.Config.MongoDB.TenantMode == config.TenantModeField
+}
+
+// ensurePersonIndexes makes ids unique within the tenant: per tenant in the shared collection, or
+// in the tenant's own collection, which is indexed the first time a person is created in it.
+func (r *RepoHandler) ensurePersonIndexes(ctx context.Context, tenant string) error {
+	collection := r.collection(tenant)
+	if _, ok := r.indexed.Load(collection.Name()); ok {
+		return nil
+	}
+
+	keys := bson.D{{Key: "id", Value: 1}}
+	if r.sharedCollection() {
+		keys = bson.D{{Key: "tenant", Value: 1}, {Key: "id", Value: 1}}
+	}
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to create person indexes in MongoDB")
+
+		return err
+	}
+
+	r.indexed.Store(collection.Name(), true)
+
+	return nil
+}
+
+// tenantFilter scopes a filter to the tenant when tenants share a collection.
+func (r *RepoHandler) tenantFilter(tenant string, filter bson.M) bson.M {
+	if r.Config.MongoDB.TenantMode == config.TenantModeField {
+		filter["tenant"] = tenant
+	}
+
+	return filter
+}
+
+// accessFilter scopes a filter to the principal's tenant and, unless the principal is an admin,
+// to the people it owns or is granted through one of the grantFields.
+func (r *RepoHandler) accessFilter(principal *models.Principal, filter bson.M, grantFields ...string) bson.M {
+	filter = r.tenantFilter(principal.Tenant, filter)
+	if principal.IsAdmin {
+		return filter
+	}
+
+	access := bson.A{bson.M{"owner": principal.UserName}}
+	for _, field := range grantFields {
+		access = append(access, bson.M{field: bson.M{"$in": principal.Grantees()}})
+	}
+
+	filter["$or"] = access
+
+	return filter
+}
+
+// readFilter matches the people the principal may read.
+func (r *RepoHandler) readFilter(principal *models.Principal, filter bson.M) bson.M {
+	return r.accessFilter(principal, filter, "readers", "writers")
+}
+
+// writeFilter matches the people the principal may change.
+func (r *RepoHandler) writeFilter(principal *models.Principal, filter bson.M) bson.M {
+	return r.accessFilter(principal, filter, "writers")
+}
+
+// personFilter adds the exact matches of the person filter, through the blind index of encrypted fields.
+func (r *RepoHandler) personFilter(filter bson.M, personFilter models.PersonFilter) bson.M {
+	fields := map[string]string{
+		"name":     personFilter.Name,
+		"lastName": personFilter.LastName,
+	}
+
+	for field, value := range fields {
+		if value == "" {
+			continue
+		}
+
+		if r.encryptor.IsEncrypted(field) {
+			filter[encryption.IndexField(field)] = r.encryptor.BlindIndex(field, value)
+		} else {
+			filter[field] = value
+		}
+	}
+
+	return filter
+}
+
+// decodePerson decrypts a stored document into a person.
+func (r *RepoHandler) decodePerson(raw bson.Raw) (*models.Person, error) {
+	doc := bson.M{}
+	err := bson.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.encryptor.Open(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := bson.Marshal(doc)
 	if err != nil {
-		log.WithError(err).Error("Failed to ping MongoDB")
 		return nil, err
 	}
 
-	log.Println("Connected to MongoDB")
+	var person models.Person
+	err = bson.Unmarshal(data, &person)
+	if err != nil {
+		return nil, err
+	}
 
-	return client, nil
+	return &person, nil
 }
 
-func (r *RepoHandler) GetPersonList() ([]models.Person, error) {
+// GetPersonList returns the people the principal may read that match the filter ordered by id,
+// skipping the first offset documents. A limit of 0 returns every remaining document.
+func (r *RepoHandler) GetPersonList(ctx context.Context, principal *models.Principal, personFilter models.PersonFilter, offset int64, limit int64) ([]models.Person, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
 	people := []models.Person{}
 
 	// Get a handle to the collection
-	collection := r.client.Database(r.Config.MongoDB.Database).Collection(r.Config.MongoDB.Collection)
+	collection := r.collection(principal.Tenant)
 
-	// Find all documents in the collection
-	cur, err := collection.Find(context.Background(), bson.D{})
+	findOptions := options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).SetSkip(offset)
+	if limit > 0 {
+		findOptions.SetLimit(limit)
+	}
+
+	// Find the documents in the collection
+	cur, err := collection.Find(ctx, r.readFilter(principal, r.personFilter(bson.M{}, personFilter)), findOptions)
 	if err != nil {
-		log.WithError(err).Error("Failed to find documents in MongoDB")
+		r.logger(ctx).WithError(err).Error("Failed to find documents in MongoDB")
 
 		return nil, err
 	}
 
-	defer cur.Close(context.Background())
+	defer cur.Close(ctx)
 
-	// Iterate over the documents and print their contents
-	for cur.Next(context.Background()) {
-		var doc bson.M
-		err := cur.Decode(&doc)
+	// Iterate over the documents and decode them
+	for cur.Next(ctx) {
+		person, err := r.decodePerson(cur.Current)
 		if err != nil {
-			log.Error(err)
+			r.logger(ctx).Error(err)
+
+			continue
 		}
 
-		people = append(people, models.Person{
-			ID:       doc["id"].(string),
-			Name:     doc["name"].(string),
-			LastName: doc["lastName"].(string),
-			Age:      doc["age"].(int32),
-		})
+		people = append(people, *person)
 	}
 
 	if err := cur.Err(); err != nil {
-		log.WithError(err).Error("Failed to iterate over documents in MongoDB")
+		r.logger(ctx).WithError(err).Error("Failed to iterate over documents in MongoDB")
 
 		return nil, err
 	}
@@ -107,117 +294,222 @@ func (r *RepoHandler) GetPersonList() ([]models.Person, error) {
 	return people, nil
 }
 
-func (r *RepoHandler) GetPerson(id string) (*models.Person, error) {
-	// Get the database and collection
-	db := r.client.Database(r.Config.MongoDB.Database)
-	collection := db.Collection(r.Config.MongoDB.Collection)
+// GetPerson returns the person if the principal may read it, nil otherwise.
+func (r *RepoHandler) GetPerson(ctx context.Context, principal *models.Principal, id string) (*models.Person, error) {
+	return r.findPerson(ctx, principal.Tenant, r.readFilter(principal, bson.M{"id": id}))
+}
+
+func (r *RepoHandler) findPerson(ctx context.Context, tenant string, filter bson.M) (*models.Person, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+	// Get the collection
+	collection := r.collection(tenant)
 
 	// Find the document by ID
-	filter := bson.M{"id": id}
-	var person models.Person
-	err := collection.FindOne(context.Background(), filter).Decode(&person)
+	raw, err := collection.FindOne(ctx, filter).Raw()
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
 
-		log.WithError(err).Error("Failed to find document in MongoDB")
+		r.logger(ctx).WithError(err).Error("Failed to find document in MongoDB")
 
 		return nil, err
 	}
 
-	return &person, nil
+	person, err := r.decodePerson(raw)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to decrypt document from MongoDB")
+
+		return nil, err
+	}
+
+	return person, nil
 }
 
-func (r *RepoHandler) CreatePerson(person *models.Person) error {
-	existentPerson, err := r.GetPerson(person.ID)
+// CreatePerson stores the person owned by the principal. Ids are unique within the tenant,
+// regardless of who may see the existing person, the unique index settles concurrent creates.
+func (r *RepoHandler) CreatePerson(ctx context.Context, principal *models.Principal, person *models.Person) error {
+	tenant := principal.Tenant
+
+	err := r.ensurePersonIndexes(ctx, tenant)
+	if err != nil {
+		return err
+	}
+
+	existentPerson, err := r.findPerson(ctx, tenant, r.tenantFilter(tenant, bson.M{"id": person.ID}))
 	if err != nil {
 		//will need to check for not found
-		log.WithError(err).Error("Failed to get person from MongoDB")
+		r.logger(ctx).WithError(err).Error("Failed to get person from MongoDB")
 
 		return err
 	}
 
 	if existentPerson != nil {
-		log.WithField("id", person.ID).Info("Person already exists")
+		r.logger(ctx).WithField("id", person.ID).Info("Person already exists")
 
 		return fmt.Errorf("person with ID %s already exists", person.ID)
 	}
 
 	// Insert the person into the "people" collection
-	collection := r.client.Database(r.Config.MongoDB.Database).Collection(r.Config.MongoDB.Collection)
+	collection := r.collection(tenant)
 
 	doc := bson.D{}
 
-	// Add fields to the document
+	// Add fields to the document, encrypting the configured ones
+	sealed, err := r.encryptor.Seal(bson.D{
+		{Key: "name", Value: person.Name},
+		{Key: "lastName", Value: person.LastName},
+	})
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to encrypt person")
+
+		return err
+	}
+
 	doc = append(doc, bson.E{Key: "id", Value: person.ID})
-	doc = append(doc, bson.E{Key: "name", Value: person.Name})
-	doc = append(doc, bson.E{Key: "lastName", Value: person.LastName})
+	doc = append(doc, sealed...)
 	doc = append(doc, bson.E{Key: "age", Value: person.Age})
+	doc = append(doc, bson.E{Key: "owner", Value: principal.UserName})
+
+	if r.Config.MongoDB.TenantMode == config.TenantModeField {
+		doc = append(doc, bson.E{Key: "tenant", Value: tenant})
+	}
 
 	// Convert the document to BSON
 	personBSON, err := bson.Marshal(doc)
 	if err != nil {
-		log.WithError(err).Error("Failed to marshal person to BSON")
+		r.logger(ctx).WithError(err).Error("Failed to marshal person to BSON")
 		return err
 	}
 
-	_, err = collection.InsertOne(context.Background(), personBSON)
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	_, err = collection.InsertOne(ctx, personBSON)
+	if mongo.IsDuplicateKeyError(err) {
+		r.logger(ctx).WithField("id", person.ID).Info("Person already exists")
+
+		return fmt.Errorf("person with ID %s already exists", person.ID)
+	}
+
 	if err != nil {
-		log.WithError(err).Error("Failed to insert person into MongoDB")
+		r.logger(ctx).WithError(err).Error("Failed to insert person into MongoDB")
 
 		return err
 	}
 
-	log.WithField("id", person.ID).Info("Person inserted successfully")
+	r.logger(ctx).WithField("id", person.ID).Info("Person inserted successfully")
 
 	return nil
 }
 
-func (r *RepoHandler) DeletePerson(id string) error {
-	person, err := r.GetPerson(id)
+// DeletePerson deletes the person if the principal may change it.
+func (r *RepoHandler) DeletePerson(ctx context.Context, principal *models.Principal, id string) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	// Get the collection
+	collection := r.collection(principal.Tenant)
+
+	// Delete the document by ID
+	filter := r.writeFilter(principal, bson.M{"id": id})
+	result, err := collection.DeleteOne(ctx, filter)
 	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to delete document from MongoDB")
+
 		return err
 	}
 
-	if person == nil {
-		return fmt.Errorf("person with ID %s not found", id)
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("person with ID %s: %w", id, ErrNotFound)
 	}
 
-	// Get the database and collection
-	db := r.client.Database(r.Config.MongoDB.Database)
-	collection := db.Collection(r.Config.MongoDB.Collection)
+	r.logger(ctx).WithField("id", id).Info("Person deleted successfully")
 
-	// Delete the document by ID
-	filter := bson.M{"id": id}
-	_, err = collection.DeleteOne(context.Background(), filter)
+	return nil
+}
+
+// UpdatePerson updates the person if the principal may change it.
+func (r *RepoHandler) UpdatePerson(ctx context.Context, principal *models.Principal, person *models.Person) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	// Get the collection
+	collection := r.collection(principal.Tenant)
+
+	// Update the document by ID, the id, tenant, owner and grants are never changed here
+	filter := r.writeFilter(principal, bson.M{"id": person.ID})
+
+	sealed, err := r.encryptor.Seal(bson.D{
+		{Key: "name", Value: person.Name},
+		{Key: "lastName", Value: person.LastName},
+	})
 	if err != nil {
-		log.WithError(err).Error("Failed to delete document from MongoDB")
+		r.logger(ctx).WithError(err).Error("Failed to encrypt person")
 
 		return err
 	}
 
-	log.WithField("id", id).Info("Person deleted successfully")
+	set := bson.M{"age": person.Age}
+	for _, field := range sealed {
+		set[field.Key] = field.Value
+	}
+
+	update := bson.M{"$set": set}
+
+	// Drop the envelope of an earlier encryption when no field is encrypted anymore
+	if _, ok := set[encryption.EnvelopeField]; !ok {
+		unset := bson.M{encryption.EnvelopeField: ""}
+		for _, field := range encryption.Fields {
+			unset[encryption.IndexField(field)] = ""
+		}
+
+		update["$unset"] = unset
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to update document in MongoDB")
+
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("person with ID %s: %w", person.ID, ErrNotFound)
+	}
+
+	r.logger(ctx).WithField("id", person.ID).Info("Person updated successfully")
 
 	return nil
 }
 
-func (r *RepoHandler) UpdatePerson(person *models.Person) error {
-	// Get the database and collection
-	db := r.client.Database(r.Config.MongoDB.Database)
-	collection := db.Collection(r.Config.MongoDB.Collection)
+// SetGrants replaces who may read and write the person. Only the owner or an admin may do so.
+func (r *RepoHandler) SetGrants(ctx context.Context, principal *models.Principal, id string, grants *models.Grants) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	// Get the collection
+	collection := r.collection(principal.Tenant)
 
-	// Update the document by ID
-	filter := bson.M{"id": person.ID}
-	update := bson.M{"$set": person}
-	_, err := collection.UpdateOne(context.Background(), filter, update)
+	// Only the owner field is matched, grants never allow changing grants
+	filter := r.accessFilter(principal, bson.M{"id": id})
+	update := bson.M{"$set": bson.M{
+		"readers": grants.Readers,
+		"writers": grants.Writers,
+	}}
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		log.WithError(err).Error("Failed to update document in MongoDB")
+		r.logger(ctx).WithError(err).Error("Failed to update grants in MongoDB")
 
 		return err
 	}
 
-	log.WithField("id", person.ID).Info("Person updated successfully")
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("person with ID %s: %w", id, ErrNotFound)
+	}
+
+	r.logger(ctx).WithField("id", id).Info("Person grants updated successfully")
 
 	return nil
 }
@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/pavr1/people_project/people/config"
+	"github.com/pavr1/people_project/people/handlers/encryption"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RotateKeys re-encrypts every person of every tenant under a new data key wrapped by the current
+// master key, encrypting exactly the configured fields. It returns how many people were rewritten.
+// People changed while rotating already use the current key and are skipped.
+func (r *RepoHandler) RotateKeys(ctx context.Context) (int64, error) {
+	names, err := r.personCollections(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := int64(0)
+	database := r.client.Database(r.Config.MongoDB.Database)
+
+	for _, name := range names {
+		collection := database.Collection(name)
+
+		cur, err := collection.Find(ctx, bson.M{})
+		if err != nil {
+			r.logger(ctx).WithError(err).Error("Failed to find documents in MongoDB")
+
+			return rotated, err
+		}
+
+		for cur.Next(ctx) {
+			doc := bson.M{}
+			err = cur.Decode(&doc)
+			if err != nil {
+				cur.Close(ctx)
+
+				return rotated, err
+			}
+
+			// Only replace the document if nobody re-encrypted it in between
+			filter := bson.M{"_id": doc["_id"], encryption.EnvelopeField: bson.M{"$exists": false}}
+			if wrappedKey := encryption.WrappedKey(doc); wrappedKey != nil {
+				filter = bson.M{"_id": doc["_id"], encryption.EnvelopeField + ".dataKey": wrappedKey}
+			}
+
+			err = r.encryptor.Open(doc)
+			if err != nil {
+				cur.Close(ctx)
+				r.logger(ctx).WithError(err).WithField("_id", doc["_id"]).Error("Failed to decrypt document")
+
+				return rotated, err
+			}
+
+			fields := bson.D{}
+			for _, field := range encryption.Fields {
+				if value, ok := doc[field]; ok {
+					fields = append(fields, bson.E{Key: field, Value: value})
+					delete(doc, field)
+				}
+			}
+
+			sealed, err := r.encryptor.Seal(fields)
+			if err != nil {
+				cur.Close(ctx)
+
+				return rotated, err
+			}
+
+			for _, field := range sealed {
+				doc[field.Key] = field.Value
+			}
+
+			writeCtx, cancel := r.writeContext(ctx)
+			result, err := collection.ReplaceOne(writeCtx, filter, doc)
+			cancel()
+
+			if err != nil {
+				cur.Close(ctx)
+				r.logger(ctx).WithError(err).Error("Failed to replace document in MongoDB")
+
+				return rotated, err
+			}
+
+			rotated += result.ModifiedCount
+		}
+
+		err = cur.Err()
+		cur.Close(ctx)
+
+		if err != nil {
+			r.logger(ctx).WithError(err).Error("Failed to iterate over documents in MongoDB")
+
+			return rotated, err
+		}
+
+		r.logger(ctx).WithField("collection", name).Info("Collection rotated")
+	}
+
+	return rotated, nil
+}
+
+// personCollections returns the collections holding people, one per tenant in collection mode.
+func (r *RepoHandler) personCollections(ctx context.Context) ([]string, error) {
+	if r.Config.MongoDB.TenantMode != config.TenantModeCollection {
+		return []string{r.Config.MongoDB.Collection}, nil
+	}
+
+	filter := bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(r.Config.MongoDB.Collection+"_")}}
+
+	names, err := r.client.Database(r.Config.MongoDB.Database).ListCollectionNames(ctx, filter)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to list collections in MongoDB")
+
+		return nil, err
+	}
+
+	return names, nil
+}
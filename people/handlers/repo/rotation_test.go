@@ -0,0 +1,126 @@
+package repo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/pavr1/people_project/people/handlers/encryption"
+	"github.com/pavr1/people_project/people/models"
+	"github.com/pavr1/people_project/shared/secrets"
+)
+
+// testKeyring returns a keyring of the keys by ID, current wrapping new data keys.
+func testKeyring(t *testing.T, current string, ids ...string) string {
+	t.Helper()
+
+	keys := map[string]string{}
+	for _, id := range ids {
+		keys[id] = base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id, 32)[:32]))
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"current":  current,
+		"keys":     keys,
+		"indexKey": base64.StdEncoding.EncodeToString([]byte(strings.Repeat("i", 32))),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestRotateKeys(t *testing.T) {
+	uri := os.Getenv("PEOPLE_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("PEOPLE_TEST_MONGODB_URI is not set")
+	}
+
+	t.Setenv("ENCRYPTION_FIELDS", "name,lastName")
+	t.Setenv("ENCRYPTION_KEYRING", testKeyring(t, "a", "a"))
+
+	r := newTestRepoHandler(t, uri)
+	ctx := context.Background()
+	principal := &models.Principal{Tenant: "acme", IsAdmin: true}
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	// useKeyring replaces the keyring of r, as a restart with another keyring would
+	useKeyring := func(keyring string) {
+		r.Config.Encryption.Keyring = secrets.New(keyring)
+
+		encryptor, err := encryption.NewEncryptor(logger, r.Config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.encryptor = encryptor
+	}
+
+	// keyIDs returns the master key IDs the stored data keys of the tenant are wrapped with
+	keyIDs := func() map[string]int {
+		cur, err := r.collection(principal.Tenant).Find(ctx, r.tenantFilter(principal.Tenant, bson.M{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		docs := []bson.M{}
+
+		err = cur.All(ctx, &docs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := map[string]int{}
+		for _, doc := range docs {
+			envelope, _ := doc[encryption.EnvelopeField].(bson.M)
+			ids[envelope["keyId"].(string)]++
+		}
+
+		return ids
+	}
+
+	useKeyring(testKeyring(t, "b", "a", "b"))
+
+	rotated, err := r.RotateKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	people := int64(len(testPeople["acme"]) + len(testPeople["globex"]))
+	if rotated != people {
+		t.Errorf("rotated = %d, want %d", rotated, people)
+	}
+
+	if ids := keyIDs(); ids["b"] != len(testPeople["acme"]) || len(ids) != 1 {
+		t.Errorf("data keys are wrapped with %v, want only b", ids)
+	}
+
+	// The retired key is no longer needed to read the people stored before the rotation
+	useKeyring(testKeyring(t, "b", "b"))
+
+	for _, want := range testPeople["acme"] {
+		person, err := r.GetPerson(ctx, principal, want.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if person == nil || person.Name != want.Name || person.LastName != want.LastName {
+			t.Errorf("person %s = %+v, want %s %s", want.ID, person, want.Name, want.LastName)
+		}
+	}
+
+	list, err := r.GetPersonList(ctx, principal, models.PersonFilter{LastName: "Lovelace"}, 0, 0)
+	if err != nil || len(list) != 2 {
+		t.Errorf("people found by last name = %v, %v, want the 2 Lovelaces", list, err)
+	}
+}
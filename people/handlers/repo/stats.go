@@ -0,0 +1,314 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pavr1/people_project/people/handlers/encryption"
+	"github.com/pavr1/people_project/people/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// namespaceNotFound is returned when listing the indexes of a collection that does not exist yet.
+const namespaceNotFound = 26
+
+// ErrNotIndexed is returned for a group by field without an index, grouping would scan the
+// collection.
+var ErrNotIndexed = errors.New("not indexed")
+
+// ErrEncrypted is returned for a group by field that is encrypted, its values differ per document.
+var ErrEncrypted = errors.New("encrypted")
+
+// StatsStore aggregates the people a principal may read. RepoHandler aggregates in MongoDB, the
+// tests also use an implementation over people kept in the process.
+type StatsStore interface {
+	GetPersonStats(ctx context.Context, principal *models.Principal, statsOptions models.StatsOptions) (*models.PersonStats, error)
+}
+
+// GetPersonStats aggregates the people the principal may read, the same people GetPersonList returns.
+func (r *RepoHandler) GetPersonStats(ctx context.Context, principal *models.Principal, statsOptions models.StatsOptions) (*models.PersonStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Config.Timeouts.Aggregate)
+	defer cancel()
+
+	collection := r.collection(principal.Tenant)
+	filter := r.readFilter(principal, r.personFilter(bson.M{}, statsOptions.Filter))
+
+	if r.encryptor.IsEncrypted(statsOptions.GroupBy) {
+		return nil, fmt.Errorf("group by field %s is %w", statsOptions.GroupBy, ErrEncrypted)
+	}
+
+	if statsOptions.GroupBy != "" {
+		indexed, err := r.indexedFields(ctx, collection)
+		if err != nil {
+			return nil, err
+		}
+
+		if !indexed[statsOptions.GroupBy] {
+			return nil, fmt.Errorf("group by field %s is %w", statsOptions.GroupBy, ErrNotIndexed)
+		}
+	}
+
+	// Encrypted last names differ per document, they are counted by blind index and one of
+	// them is decrypted for the name
+	lastNameGroup := bson.M{"_id": "$lastName", "count": bson.M{"$sum": 1}}
+	if r.encryptor.IsEncrypted("lastName") {
+		lastNameGroup["_id"] = "$" + encryption.IndexField("lastName")
+		lastNameGroup["sample"] = bson.M{"$first": bson.M{
+			"lastName":               "$lastName",
+			encryption.EnvelopeField: "$" + encryption.EnvelopeField,
+		}}
+	}
+
+	boundaries := bson.A{}
+	for _, boundary := range statsOptions.AgeBuckets {
+		boundaries = append(boundaries, boundary)
+	}
+
+	facets := bson.M{
+		"summary": bson.A{
+			bson.M{"$group": bson.M{
+				"_id":   nil,
+				"total": bson.M{"$sum": 1},
+				"min":   bson.M{"$min": "$age"},
+				"max":   bson.M{"$max": "$age"},
+				"mean":  bson.M{"$avg": "$age"},
+			}},
+		},
+		"histogram": bson.A{
+			bson.M{"$bucket": bson.M{
+				"groupBy":    "$age",
+				"boundaries": boundaries,
+				"default":    "other",
+				"output":     bson.M{"count": bson.M{"$sum": 1}},
+			}},
+		},
+		"lastNames": bson.A{
+			bson.M{"$group": lastNameGroup},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": statsOptions.Top},
+		},
+	}
+
+	if statsOptions.GroupBy != "" {
+		facets["groups"] = bson.A{
+			bson.M{"$group": bson.M{
+				"_id":   "$" + statsOptions.GroupBy,
+				"total": bson.M{"$sum": 1},
+				"min":   bson.M{"$min": "$age"},
+				"max":   bson.M{"$max": "$age"},
+				"mean":  bson.M{"$avg": "$age"},
+			}},
+			bson.M{"$sort": bson.M{"_id": 1}},
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: facets}},
+	}
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to aggregate people stats in MongoDB")
+
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	type ageSummary struct {
+		ID    interface{} `bson:"_id"`
+		Total int64       `bson:"total"`
+		Min   int32       `bson:"min"`
+		Max   int32       `bson:"max"`
+		Mean  float64     `bson:"mean"`
+	}
+
+	result := struct {
+		Summary   []ageSummary `bson:"summary"`
+		Histogram []struct {
+			ID    interface{} `bson:"_id"`
+			Count int64       `bson:"count"`
+		} `bson:"histogram"`
+		LastNames []struct {
+			ID     interface{} `bson:"_id"`
+			Count  int64       `bson:"count"`
+			Sample bson.M      `bson:"sample"`
+		} `bson:"lastNames"`
+		Groups []ageSummary `bson:"groups"`
+	}{}
+
+	if cur.Next(ctx) {
+		err = cur.Decode(&result)
+		if err != nil {
+			r.logger(ctx).WithError(err).Error("Failed to decode people stats")
+
+			return nil, err
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to iterate over people stats in MongoDB")
+
+		return nil, err
+	}
+
+	stats := models.PersonStats{
+		TopLastNames: []models.NameCount{},
+	}
+
+	if len(result.Summary) > 0 {
+		summary := result.Summary[0]
+		stats.Total = summary.Total
+		stats.Age.Min = summary.Min
+		stats.Age.Max = summary.Max
+		stats.Age.Mean = summary.Mean
+	}
+
+	// $bucket leaves out empty buckets, every configured bucket is reported
+	counts := map[int64]int64{}
+	for _, bucket := range result.Histogram {
+		lower, ok := toInt64(bucket.ID)
+		if !ok {
+			stats.Age.OutOfRange += bucket.Count
+
+			continue
+		}
+
+		counts[lower] = bucket.Count
+	}
+
+	stats.Age.Histogram = []models.AgeBucket{}
+	for i := 0; i < len(statsOptions.AgeBuckets)-1; i++ {
+		from := statsOptions.AgeBuckets[i]
+		stats.Age.Histogram = append(stats.Age.Histogram, models.AgeBucket{
+			From:  from,
+			To:    statsOptions.AgeBuckets[i+1],
+			Count: counts[int64(from)],
+		})
+	}
+
+	for _, lastName := range result.LastNames {
+		name, _ := lastName.ID.(string)
+		if lastName.Sample != nil {
+			err = r.encryptor.Open(lastName.Sample)
+			if err != nil {
+				r.logger(ctx).WithError(err).Error("Failed to decrypt last name")
+
+				return nil, err
+			}
+
+			name, _ = lastName.Sample["lastName"].(string)
+		}
+
+		stats.TopLastNames = append(stats.TopLastNames, models.NameCount{LastName: name, Count: lastName.Count})
+	}
+
+	for _, group := range result.Groups {
+		stats.Groups = append(stats.Groups, models.GroupStats{
+			Key:     group.ID,
+			Total:   group.Total,
+			MinAge:  group.Min,
+			MaxAge:  group.Max,
+			MeanAge: group.Mean,
+		})
+	}
+
+	stats.Age.Median, err = r.medianAge(ctx, collection, filter, stats.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// medianAge reads the one or two middle ages instead of loading every age, total being the
+// number of people matching filter.
+func (r *RepoHandler) medianAge(ctx context.Context, collection *mongo.Collection, filter bson.M, total int64) (float64, error) {
+	if total == 0 {
+		return 0, nil
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "age", Value: 1}}).
+		SetSkip((total - 1) / 2).
+		SetLimit(2 - total%2).
+		SetProjection(bson.M{"age": 1})
+
+	cur, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to find median age in MongoDB")
+
+		return 0, err
+	}
+
+	middle := []struct {
+		Age int32 `bson:"age"`
+	}{}
+
+	err = cur.All(ctx, &middle)
+	if err != nil {
+		r.logger(ctx).WithError(err).Error("Failed to decode median age")
+
+		return 0, err
+	}
+
+	if len(middle) == 0 {
+		return 0, nil
+	}
+
+	sum := 0.0
+	for _, person := range middle {
+		sum += float64(person.Age)
+	}
+
+	return sum / float64(len(middle)), nil
+}
+
+// indexedFields returns the fields that are part of an index of the collection, except _id.
+func (r *RepoHandler) indexedFields(ctx context.Context, collection *mongo.Collection) (map[string]bool, error) {
+	fields := map[string]bool{}
+
+	specs, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		var commandErr mongo.CommandError
+		if errors.As(err, &commandErr) && commandErr.Code == namespaceNotFound {
+			return fields, nil
+		}
+
+		r.logger(ctx).WithError(err).Error("Failed to list indexes in MongoDB")
+
+		return nil, err
+	}
+
+	for _, spec := range specs {
+		elements, err := spec.KeysDocument.Elements()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, element := range elements {
+			if element.Key() != "_id" {
+				fields[element.Key()] = true
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch number := value.(type) {
+	case int32:
+		return int64(number), true
+	case int64:
+		return number, true
+	case float64:
+		return int64(number), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,232 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/pavr1/people_project/people/config"
+	"github.com/pavr1/people_project/people/models"
+)
+
+// testPeople are stored for the tenant of their key, owned by their Owner.
+var testPeople = map[string][]models.Person{
+	"acme": {
+		{ID: "1", Name: "Ada", LastName: "Lovelace", Age: 36, Owner: "alice"},
+		{ID: "2", Name: "Byron", LastName: "Lovelace", Age: 72, Owner: "alice"},
+		{ID: "3", Name: "Alan", LastName: "Turing", Age: 41, Owner: "alice"},
+		{ID: "4", Name: "Grace", LastName: "Hopper", Age: 85, Owner: "bob", Readers: []string{"group:navy"}},
+		{ID: "5", Name: "Edsger", LastName: "Dijkstra", Age: 17, Owner: "bob"},
+		{ID: "6", Name: "Barbara", LastName: "Liskov", Age: 160, Owner: "bob", Writers: []string{"user:alice"}},
+	},
+	"globex": {
+		{ID: "1", Name: "Ken", LastName: "Thompson", Age: 30, Owner: "alice"},
+	},
+}
+
+// testStatsStores returns the stores holding testPeople, MongoDB only when
+// PEOPLE_TEST_MONGODB_URI points to a server the test may create databases in.
+func testStatsStores(t *testing.T) map[string]StatsStore {
+	t.Helper()
+
+	memory := NewMemoryStats("lastName", "age")
+	for tenant, people := range testPeople {
+		for _, person := range people {
+			memory.Add(tenant, person)
+		}
+	}
+
+	stores := map[string]StatsStore{"memory": memory}
+
+	uri := os.Getenv("PEOPLE_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Log("PEOPLE_TEST_MONGODB_URI is not set, only the memory store is tested")
+
+		return stores
+	}
+
+	stores["mongodb"] = newTestRepoHandler(t, uri)
+
+	return stores
+}
+
+func newTestRepoHandler(t *testing.T, uri string) *RepoHandler {
+	t.Helper()
+
+	t.Setenv("SERVER_PORT", "8080")
+	t.Setenv("AUTH_PATH", "http://auth/auth/token")
+	t.Setenv("AUTH_HOST", "auth")
+	t.Setenv("MONGODB_URI", uri)
+	t.Setenv("MONGODB_DATABASE", fmt.Sprintf("people_test_%d", time.Now().UnixNano()))
+	t.Setenv("MONGODB_COLLECTION", "person")
+	t.Setenv("MONGODB_ROLE", "")
+	t.Setenv("ERASURE_KEY", "test-key")
+
+	testConfig, err := config.NewConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	r, err := NewRepoHandler(logger, testConfig, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		r.client.Database(testConfig.MongoDB.Database).Drop(context.Background())
+		r.Close(context.Background())
+	})
+
+	ctx := context.Background()
+
+	for tenant, people := range testPeople {
+		_, err = r.collection(tenant).Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "lastName", Value: 1}}},
+			{Keys: bson.D{{Key: "age", Value: 1}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, person := range people {
+			err = r.CreatePerson(ctx, &models.Principal{Tenant: tenant, UserName: person.Owner}, &person)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = r.SetGrants(ctx, &models.Principal{Tenant: tenant, IsAdmin: true}, person.ID, &models.Grants{Readers: person.Readers, Writers: person.Writers})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return r
+}
+
+func TestGetPersonStats(t *testing.T) {
+	stores := testStatsStores(t)
+
+	admin := &models.Principal{Tenant: "acme", UserName: "carol", IsAdmin: true}
+	buckets := []int32{0, 18, 65, 150}
+
+	tests := []struct {
+		name      string
+		principal *models.Principal
+		options   models.StatsOptions
+		stats     *models.PersonStats
+		err       error
+	}{
+		{
+			name:      "admin sees the whole tenant",
+			principal: admin,
+			options:   models.StatsOptions{AgeBuckets: buckets, Top: 2},
+			stats: &models.PersonStats{
+				Total: 6,
+				Age: models.AgeStats{
+					Min: 17, Max: 160, Mean: 411.0 / 6, Median: 56.5,
+					Histogram:  []models.AgeBucket{{From: 0, To: 18, Count: 1}, {From: 18, To: 65, Count: 2}, {From: 65, To: 150, Count: 2}},
+					OutOfRange: 1,
+				},
+				TopLastNames: []models.NameCount{{LastName: "Lovelace", Count: 2}, {LastName: "Dijkstra", Count: 1}},
+			},
+		},
+		{
+			name:      "owner and writer grant",
+			principal: &models.Principal{Tenant: "acme", UserName: "alice"},
+			options:   models.StatsOptions{AgeBuckets: buckets, Top: 10},
+			stats: &models.PersonStats{
+				Total: 4,
+				Age: models.AgeStats{
+					Min: 36, Max: 160, Mean: 77.25, Median: 56.5,
+					Histogram:  []models.AgeBucket{{From: 0, To: 18}, {From: 18, To: 65, Count: 2}, {From: 65, To: 150, Count: 1}},
+					OutOfRange: 1,
+				},
+				TopLastNames: []models.NameCount{{LastName: "Lovelace", Count: 2}, {LastName: "Liskov", Count: 1}, {LastName: "Turing", Count: 1}},
+			},
+		},
+		{
+			name:      "group reader",
+			principal: &models.Principal{Tenant: "acme", UserName: "dave", Groups: []string{"navy"}},
+			options:   models.StatsOptions{AgeBuckets: buckets, Top: 10},
+			stats: &models.PersonStats{
+				Total:        1,
+				Age:          models.AgeStats{Min: 85, Max: 85, Mean: 85, Median: 85, Histogram: []models.AgeBucket{{From: 0, To: 18}, {From: 18, To: 65}, {From: 65, To: 150, Count: 1}}},
+				TopLastNames: []models.NameCount{{LastName: "Hopper", Count: 1}},
+			},
+		},
+		{
+			name:      "nothing readable",
+			principal: &models.Principal{Tenant: "acme", UserName: "mallory"},
+			options:   models.StatsOptions{AgeBuckets: buckets, Top: 10},
+			stats: &models.PersonStats{
+				Age:          models.AgeStats{Histogram: []models.AgeBucket{{From: 0, To: 18}, {From: 18, To: 65}, {From: 65, To: 150}}},
+				TopLastNames: []models.NameCount{},
+			},
+		},
+		{
+			name:      "filtered and grouped",
+			principal: admin,
+			options:   models.StatsOptions{AgeBuckets: []int32{0, 100}, Top: 10, GroupBy: "lastName", Filter: models.PersonFilter{LastName: "Lovelace"}},
+			stats: &models.PersonStats{
+				Total:        2,
+				Age:          models.AgeStats{Min: 36, Max: 72, Mean: 54, Median: 54, Histogram: []models.AgeBucket{{From: 0, To: 100, Count: 2}}},
+				TopLastNames: []models.NameCount{{LastName: "Lovelace", Count: 2}},
+				Groups:       []models.GroupStats{{Key: "Lovelace", Total: 2, MinAge: 36, MaxAge: 72, MeanAge: 54}},
+			},
+		},
+		{
+			name:      "grouped by age",
+			principal: &models.Principal{Tenant: "globex", UserName: "alice"},
+			options:   models.StatsOptions{AgeBuckets: buckets, Top: 10, GroupBy: "age"},
+			stats: &models.PersonStats{
+				Total:        1,
+				Age:          models.AgeStats{Min: 30, Max: 30, Mean: 30, Median: 30, Histogram: []models.AgeBucket{{From: 0, To: 18}, {From: 18, To: 65, Count: 1}, {From: 65, To: 150}}},
+				TopLastNames: []models.NameCount{{LastName: "Thompson", Count: 1}},
+				Groups:       []models.GroupStats{{Key: int32(30), Total: 1, MinAge: 30, MaxAge: 30, MeanAge: 30}},
+			},
+		},
+		{
+			name:      "group by a field without index",
+			principal: admin,
+			options:   models.StatsOptions{AgeBuckets: buckets, Top: 10, GroupBy: "name"},
+			err:       ErrNotIndexed,
+		},
+	}
+
+	for name, store := range stores {
+		for _, test := range tests {
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				stats, err := store.GetPersonStats(context.Background(), test.principal, test.options)
+				if test.err != nil {
+					if !errors.Is(err, test.err) {
+						t.Fatalf("error = %v, want %v", err, test.err)
+					}
+
+					return
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(stats, test.stats) {
+					t.Errorf("stats = %+v, want %+v", stats, test.stats)
+				}
+			})
+		}
+	}
+}
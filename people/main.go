@@ -1,65 +1,285 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/pavr1/people_project/people/config"
+	_config "github.com/pavr1/people_project/people/config"
 	"github.com/pavr1/people_project/people/handlers/auth"
 	_http "github.com/pavr1/people_project/people/handlers/http"
+	"github.com/pavr1/people_project/people/handlers/metrics"
+	"github.com/pavr1/people_project/people/handlers/openapi"
+	"github.com/pavr1/people_project/people/handlers/policy"
 	"github.com/pavr1/people_project/people/handlers/repo"
+	"github.com/pavr1/people_project/shared/admin"
+	"github.com/pavr1/people_project/shared/configloader"
+	"github.com/pavr1/people_project/shared/health"
+	"github.com/pavr1/people_project/shared/httpclient"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/middleware"
+	"github.com/pavr1/people_project/shared/ratelimit"
+	_server "github.com/pavr1/people_project/shared/server"
+	"github.com/pavr1/people_project/shared/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
 	router := mux.NewRouter()
 
-	log := setupLogger()
-	config, err := config.NewConfig()
+	log, logSettings := setupLogger()
+
+	// rotate-keys re-encrypts the stored people and exits, e.g. as a job after a keyring change
+	args := os.Args[1:]
+	rotateKeys := len(args) > 0 && args[0] == "rotate-keys"
+	if rotateKeys {
+		args = args[1:]
+	}
+
+	config, err := _config.NewConfig(args)
+	if errors.Is(err, configloader.ErrPrinted) {
+		return
+	}
+
 	if err != nil {
 		log.WithError(err).Error("Failed to create config")
 
-		return
+		os.Exit(1)
 	}
 
-	repoHandler, err := repo.NewRepoHandler(log, config)
+	logSettings.Configure(config.Log.Level, config.Log.Format, config.Log.ReportCaller)
+
+	// Every metric of the service is served at /metrics from this registry
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	repoHandler, err := repo.NewRepoHandler(log, config, registry)
 	if err != nil {
 		log.WithError(err).Error("Failed to create repo handler")
 
 		return
 	}
 
-	authHandler := auth.NewAuth(log, config)
-	httpHandler := _http.NewHttpHandler(authHandler, repoHandler, log)
+	if rotateKeys {
+		rotated, err := repoHandler.RotateKeys(context.Background())
+		if err != nil {
+			log.WithError(err).Error("Failed to rotate encryption keys")
+
+			os.Exit(1)
+		}
+
+		log.WithField("people", rotated).Info("Encryption keys rotated")
+
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), log, "people", config.Tracing)
+	if err != nil {
+		log.WithError(err).Error("Failed to set up tracing")
+
+		return
+	}
+
+	policy, err := policy.NewPolicy(log, config)
+	if err != nil {
+		log.WithError(err).Error("Failed to create access policy")
+
+		return
+	}
+
+	outbound, err := httpclient.NewMetrics(registry)
+	if err != nil {
+		log.WithError(err).Error("Failed to register outbound metrics")
+
+		return
+	}
+
+	authClient := httpclient.New(log, "auth", config.Timeouts.Auth, config.Outbound.Auth, outbound)
+
+	authHandler := auth.NewAuth(log, config, authClient)
+
+	requestMetrics, err := middleware.NewMetrics(registry)
+	if err != nil {
+		log.WithError(err).Error("Failed to register request metrics")
+
+		return
+	}
+
+	openApiHandler, err := openapi.NewOpenApiHandler(log, config)
+	if err != nil {
+		log.WithError(err).Error("Failed to create openapi handler")
+
+		return
+	}
+
+	rateLimitOptions, err := ratelimit.ParseOptions(config.RateLimit.Default, config.RateLimit.Routes)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse rate limits")
+
+		return
+	}
+
+	trustedProxies, err := ratelimit.ParseCIDRs(config.RateLimit.TrustedProxies)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse trusted proxies")
+
+		return
+	}
+
+	rateLimitOptions.Route = _http.Route
+	rateLimitOptions.TrustedProxies = trustedProxies
+
+	limiter := ratelimit.NewLimiter(log, ratelimit.NewMemoryBackend(10*time.Minute, config.RateLimit.MaxKeys), rateLimitOptions)
+
+	// The handlers rate limit the API routes once the token is verified, keyed on its principal
+	httpHandler := _http.NewHttpHandler(authHandler, repoHandler, repoHandler, policy, limiter, log)
+
+	// Every route is validated, the other routes are rate limited by client IP; the API routes are
+	// traced, logged and measured as well
+	base := middleware.New(middleware.Recover(log), limiter.Middleware, openApiHandler.Middleware)
+	api := middleware.New(tracing.Middleware(_http.Route), logging.Middleware(log), middleware.Log(log, _http.Route), requestMetrics.Middleware(_http.Route))
+
+	var pusher *metrics.Pusher
+	if config.Metrics.Push {
+		metricsClient := httpclient.New(log, "metrics", config.Timeouts.Metrics, config.Outbound.Metrics, outbound)
+		pusher, err = metrics.NewPusher(log, config, metricsClient, registry)
+		if err != nil {
+			log.WithError(err).Error("Failed to create metrics pusher")
+
+			return
+		}
+
+		api = api.Append(middleware.Observe(_http.Route, pusher.Observe))
+	}
+
+	api = api.Append(middleware.Recover(log), openApiHandler.Middleware)
+
+	router.Handle("/openapi.json", base.ThenFunc(openApiHandler.ServeSpec))
+	router.Handle("/docs", base.ThenFunc(openApiHandler.ServeDocs))
+
+	// log.*, auth.*, rate_limit.default and rate_limit.routes are reloadable, the rest needs a restart
+	reloader, err := configloader.NewReloader(log, config, configloader.ReloadOptions[_config.Config]{
+		Options:    _config.Options(args),
+		Registerer: registry,
+		Apply: func(previous *_config.Config, next *_config.Config) {
+			logSettings.Configure(next.Log.Level, next.Log.Format, next.Log.ReportCaller)
+			authHandler.SetConfig(next)
+
+			rateLimitOptions, err := ratelimit.ParseOptions(next.RateLimit.Default, next.RateLimit.Routes)
+			if err != nil {
+				log.WithError(err).Error("Failed to parse reloaded rate limits")
+
+				return
+			}
+
+			limiter.SetLimits(rateLimitOptions.Default, rateLimitOptions.Routes)
+		},
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to create config reloader")
+
+		return
+	}
+
+	router.Handle("/metrics", base.Then(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+
+	checker := health.NewChecker(log, config.Readiness.CacheTTL, config.Readiness.Timeout)
+	checker.Add("mongodb", repoHandler.Ping)
+	checker.Add("auth", authHandler.Ready)
+
+	// The metrics service is only a dependency when metrics are pushed to it
+	if pusher != nil {
+		metricsReadyUrl, err := health.ReadyURL(config.Metrics.Url)
+		if err != nil {
+			log.WithError(err).Error("Failed to parse METRICS_URL")
+
+			return
+		}
+
+		checkClient := &http.Client{Timeout: config.Readiness.Timeout}
+		checker.Add("metrics", health.HTTPCheck(checkClient, metricsReadyUrl))
+	}
+
+	router.Handle("/livez", base.ThenFunc(checker.Live))
+	router.Handle("/readyz", base.ThenFunc(checker.Ready))
+
+	router.Handle("/person/list", api.ThenFunc(httpHandler.GetPersonList))
+	router.Handle("/person/stats", api.ThenFunc(httpHandler.GetPersonStats))
+	router.Handle("/person/create", api.ThenFunc(httpHandler.CreatePerson))
+	router.Handle("/person/update", api.ThenFunc(httpHandler.UpdatePerson))
+	router.Handle("/person/delete/{id}", api.ThenFunc(httpHandler.DeletePerson))
+	router.Handle("/person/grant/{id}", api.ThenFunc(httpHandler.GrantPerson))
+	router.Handle("/person/export/{id}", api.ThenFunc(httpHandler.ExportPerson))
+	router.Handle("/person/erase/{id}", api.ThenFunc(httpHandler.ErasePerson))
+	router.Handle("/person/erasures", api.ThenFunc(httpHandler.GetErasureLog))
+	router.Handle("/person/{id}", api.ThenFunc(httpHandler.GetPerson))
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Server.Port),
+		Handler: router,
+	}
+
+	background, stopBackground := context.WithCancel(context.Background())
+	go reloader.Run(background)
+	go authHandler.Run(background)
 
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+	adminServer := admin.NewServer(log, config.Admin, admin.Options{
+		Service:  "people",
+		Settings: logSettings,
+		Config:   func() interface{} { return reloader.Current() },
 	})
+	go adminServer.Run(background)
 
-	router.HandleFunc("/person/list", httpHandler.Middleware(httpHandler.GetPersonList, httpHandler.PrometheusLog))
-	router.HandleFunc("/person/create", httpHandler.Middleware(httpHandler.CreatePerson, httpHandler.PrometheusLog))
-	router.HandleFunc("/person/update", httpHandler.Middleware(httpHandler.UpdatePerson, httpHandler.PrometheusLog))
-	router.HandleFunc("/person/delete/{id}", httpHandler.Middleware(httpHandler.DeletePerson, httpHandler.PrometheusLog))
-	router.HandleFunc("/person/{id}", httpHandler.Middleware(httpHandler.GetPerson, httpHandler.PrometheusLog))
+	if pusher != nil {
+		go pusher.Run(background)
+	}
 
 	log.WithField("port", config.Server.Port).Info("Listening to Server...")
-	// Start the HTTP server
-	log.Error(http.ListenAndServe(fmt.Sprintf(":%d", config.Server.Port), router))
+	// Start the HTTP server and drain it on SIGINT or SIGTERM
+	err = _server.Run(log, server, _server.Shutdown{
+		Delay:   config.Shutdown.Delay,
+		Timeout: config.Shutdown.Timeout,
+		Checker: checker,
+	})
+	if err != nil {
+		log.WithError(err).Error("Server failed")
+	}
+
+	stopBackground()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeouts.Write)
+	defer cancel()
+
+	// Requests served while draining are still reported
+	if pusher != nil {
+		pusher.Flush(ctx)
+	}
+
+	repoHandler.Close(ctx)
+
+	err = shutdownTracing(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to flush traces")
+	}
 }
 
-func setupLogger() *log.Logger {
+func setupLogger() (*log.Logger, *logging.Settings) {
 	logger := log.New()
-	logger.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-	})
-
-	logger.SetReportCaller(true)
-	logger.SetLevel(log.DebugLevel)
+	logger.AddHook(tracing.LogHook{})
 
 	// Set the output to stdout
 	logger.SetOutput(os.Stdout)
 
-	return logger
+	// Until the config is loaded
+	settings := logging.NewSettings(logger)
+	settings.Configure("info", logging.FormatText, true)
+
+	return logger, settings
 }
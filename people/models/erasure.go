@@ -0,0 +1,72 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// SubjectExport is everything stored about one person, as returned to a subject access request.
+type SubjectExport struct {
+	ExportedAt time.Time `json:"exportedAt"`
+	Tenant     string    `json:"tenant"`
+	// Record is the stored document as is, including owner and grants
+	Record map[string]interface{} `json:"record"`
+}
+
+// A receipt is pending from before the person is deleted until after it, so a person is never gone
+// without one.
+const (
+	ErasurePending   = "pending"
+	ErasureCompleted = "completed"
+)
+
+// ErasureReceipt records that a person was erased without keeping any of its data. Receipts of a
+// tenant form a chain of HMACs: without the erasure key, changing, reordering or removing one
+// breaks the chain after it.
+type ErasureReceipt struct {
+	Tenant   string `json:"tenant" bson:"tenant"`
+	Sequence int64  `json:"sequence" bson:"sequence"`
+	// SubjectHash identifies the erased id without storing it
+	SubjectHash  string    `json:"subjectHash" bson:"subjectHash"`
+	ErasedBy     string    `json:"erasedBy" bson:"erasedBy"`
+	ErasedAt     time.Time `json:"erasedAt" bson:"erasedAt"`
+	Records      int64     `json:"records" bson:"records"`
+	PreviousHash string    `json:"previousHash" bson:"previousHash"`
+	Hash         string    `json:"hash" bson:"hash"`
+	// Status is ErasurePending or ErasureCompleted, it is not hashed so completing a receipt
+	// keeps the chain intact
+	Status string `json:"status" bson:"status"`
+}
+
+// ErasureLog is the receipt chain of a tenant and the outcome of verifying it.
+type ErasureLog struct {
+	Receipts []ErasureReceipt `json:"receipts"`
+	Valid    bool             `json:"valid"`
+	// Problem describes the first broken link when the chain is not valid
+	Problem string `json:"problem,omitempty"`
+}
+
+// SubjectHash is the HMAC of a person id within its tenant, so the ids of a leaked receipt
+// cannot be found by hashing guesses without key.
+func SubjectHash(key []byte, tenant string, id string) string {
+	return keyedHash(key, tenant+"\x00"+id)
+}
+
+// ComputeHash is the HMAC of every field of the receipt but Hash itself. ErasedAt must already be
+// truncated to milliseconds, the precision it is stored with.
+func (e *ErasureReceipt) ComputeHash(key []byte) string {
+	data := fmt.Sprintf("%s|%d|%s|%s|%s|%d|%s",
+		e.Tenant, e.Sequence, e.SubjectHash, e.ErasedBy, e.ErasedAt.UTC().Format(time.RFC3339Nano), e.Records, e.PreviousHash)
+
+	return keyedHash(key, data)
+}
+
+func keyedHash(key []byte, data string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErasureHashesDependOnKey(t *testing.T) {
+	receipt := ErasureReceipt{
+		Tenant:      "acme",
+		Sequence:    1,
+		SubjectHash: SubjectHash([]byte("key"), "acme", "1"),
+		ErasedBy:    "alice",
+		ErasedAt:    time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC),
+		Records:     1,
+	}
+
+	tests := []struct {
+		name    string
+		subject string
+		hash    string
+		same    bool
+	}{
+		{name: "same key", subject: SubjectHash([]byte("key"), "acme", "1"), hash: receipt.ComputeHash([]byte("key")), same: true},
+		{name: "other key", subject: SubjectHash([]byte("other"), "acme", "1"), hash: receipt.ComputeHash([]byte("other")), same: false},
+		{name: "no key", subject: SubjectHash(nil, "acme", "1"), hash: receipt.ComputeHash(nil), same: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if (test.subject == receipt.SubjectHash) != test.same {
+				t.Errorf("subject hash %s against %s, want same %t", test.subject, receipt.SubjectHash, test.same)
+			}
+
+			if (test.hash == receipt.ComputeHash([]byte("key"))) != test.same {
+				t.Errorf("receipt hash %s, want same %t", test.hash, test.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// IdempotencyRecord remembers the response to a request sent with an Idempotency-Key.
+type IdempotencyRecord struct {
+	// Scope is the tenant and user the key belongs to, keys of different callers never collide
+	Scope       string `bson:"scope"`
+	Key         string `bson:"key"`
+	Fingerprint string `bson:"fingerprint"`
+	// Status is 0 while the first request with the key is still being handled
+	Status      int    `bson:"status"`
+	ContentType string `bson:"contentType,omitempty"`
+	Body        []byte `bson:"body,omitempty"`
+	// ReservedAt starts the lease of the first request, a reservation outliving it was abandoned
+	ReservedAt time.Time `bson:"reservedAt"`
+	// Lease is a random token of the request holding the reservation, only it may complete or
+	// release the key, not a request whose lease was taken over
+	Lease     string    `bson:"lease"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
@@ -6,10 +6,26 @@ import (
 
 type Person struct {
 	config   *config.Config
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	LastName string `json:"lastName"`
-	Age      int32  `json:"age"`
+	ID       string `json:"id" bson:"id"`
+	Name     string `json:"name" bson:"name"`
+	LastName string `json:"lastName" bson:"lastName"`
+	Age      int32  `json:"age" bson:"age"`
+	// Owner, Readers and Writers are managed by the service and ignored on create and update
+	Owner   string   `json:"owner,omitempty" bson:"owner,omitempty"`
+	Readers []string `json:"readers,omitempty" bson:"readers,omitempty"`
+	Writers []string `json:"writers,omitempty" bson:"writers,omitempty"`
+}
+
+// Grants lists who besides the owner may read or write a person, as user:<name> or group:<name> entries.
+type Grants struct {
+	Readers []string `json:"readers"`
+	Writers []string `json:"writers"`
+}
+
+// PersonFilter narrows the person list to exact matches of the fields that are set.
+type PersonFilter struct {
+	Name     string
+	LastName string
 }
 
 func NewPerson(config *config.Config) Person {
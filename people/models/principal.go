@@ -0,0 +1,20 @@
+package models
+
+// Principal is the caller a repository query is scoped to.
+type Principal struct {
+	Tenant   string
+	UserName string
+	Groups   []string
+	// IsAdmin lifts the row-level restrictions within the tenant
+	IsAdmin bool
+}
+
+// Grantees returns the grant entries that match the principal: user:<name> and group:<name>.
+func (p *Principal) Grantees() []string {
+	grantees := []string{"user:" + p.UserName}
+	for _, group := range p.Groups {
+		grantees = append(grantees, "group:"+group)
+	}
+
+	return grantees
+}
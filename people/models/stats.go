@@ -0,0 +1,52 @@
+package models
+
+// StatsOptions shapes a PersonStats.
+type StatsOptions struct {
+	// AgeBuckets are the ascending histogram boundaries, each bucket includes its lower bound only
+	AgeBuckets []int32
+	// Top is how many of the most common last names to return
+	Top int64
+	// GroupBy is an optional indexed field to break the totals down by
+	GroupBy string
+	// Filter narrows the people aggregated, the same way it narrows the person list
+	Filter PersonFilter
+}
+
+// PersonStats aggregates the people a principal may read.
+type PersonStats struct {
+	Total        int64        `json:"total"`
+	Age          AgeStats     `json:"age"`
+	TopLastNames []NameCount  `json:"topLastNames"`
+	Groups       []GroupStats `json:"groups,omitempty"`
+}
+
+type AgeStats struct {
+	Min       int32       `json:"min"`
+	Max       int32       `json:"max"`
+	Mean      float64     `json:"mean"`
+	Median    float64     `json:"median"`
+	Histogram []AgeBucket `json:"histogram"`
+	// OutOfRange counts the people whose age is outside every bucket
+	OutOfRange int64 `json:"outOfRange"`
+}
+
+// AgeBucket counts the people with From <= age < To.
+type AgeBucket struct {
+	From  int32 `json:"from"`
+	To    int32 `json:"to"`
+	Count int64 `json:"count"`
+}
+
+type NameCount struct {
+	LastName string `json:"lastName"`
+	Count    int64  `json:"count"`
+}
+
+// GroupStats are the totals of the people sharing one value of StatsOptions.GroupBy.
+type GroupStats struct {
+	Key     interface{} `json:"key"`
+	Total   int64       `json:"total"`
+	MinAge  int32       `json:"minAge"`
+	MaxAge  int32       `json:"maxAge"`
+	MeanAge float64     `json:"meanAge"`
+}
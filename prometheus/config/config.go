@@ -1,36 +1,90 @@
 package config
 
 import (
-	"errors"
-	"os"
-	"strconv"
+	"fmt"
+	"time"
 
+	"github.com/pavr1/people_project/shared/admin"
+	"github.com/pavr1/people_project/shared/configloader"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/secrets"
+	"github.com/pavr1/people_project/shared/tracing"
 	log "github.com/sirupsen/logrus"
 )
 
+// Config is loaded by configloader, see there for how settings map to files, environment
+// variables and flags.
 type Config struct {
+	Log struct {
+		// Level is a logrus level such as info or debug
+		Level string `mapstructure:"level" default:"debug" reload:"true"`
+		// Format is text or json
+		Format string `mapstructure:"format" default:"text" reload:"true"`
+		// ReportCaller adds the function and file of the code logging to each entry
+		ReportCaller bool `mapstructure:"report_caller" default:"true" reload:"true"`
+	} `mapstructure:"log"`
 	Prometheus struct {
-		Port int `mapstructure:"port"`
+		Port int `mapstructure:"port" validate:"required,positive"`
 	} `mapstructure:"prometheus"`
+	Ingest struct {
+		// Token must be sent as a bearer token by the services pushing to /prometheus/log
+		Token secrets.Secret `mapstructure:"token" validate:"required"`
+		// MaxRoutes bounds the distinct route labels, routes first seen after that are counted as other
+		MaxRoutes int `mapstructure:"max_routes" default:"100" validate:"positive"`
+	} `mapstructure:"ingest"`
+	Shutdown struct {
+		// Delay keeps serving after SIGTERM while readiness fails
+		Delay time.Duration `mapstructure:"delay" default:"5s"`
+		// Timeout bounds the wait for requests in flight
+		Timeout time.Duration `mapstructure:"timeout" default:"20s" validate:"positive"`
+	} `mapstructure:"shutdown"`
+	Tracing tracing.Config `mapstructure:"tracing"`
+	Admin   admin.Config   `mapstructure:"admin"`
 }
 
-func NewConfig() (*Config, error) {
-	port := os.Getenv("PROMETHEUS_PORT")
-	if port == "" {
-		log.Error("PROMETHEUS_PORT is not set")
-		return nil, errors.New("SERVER_PORT is not set")
-	}
+// Options are the loader options NewConfig(args) uses, for reloading the same configuration.
+func Options(args []string) configloader.Options {
+	return configloader.Options{Name: "prometheus", Args: args}
+}
+
+// NewConfig loads the configuration from the defaults, the optional config file, the environment
+// and args, the command line flags.
+func NewConfig(args []string) (*Config, error) {
+	var config = Config{}
 
-	portInt, err := strconv.Atoi(port)
+	err := configloader.Load(&config, Options(args))
 	if err != nil {
-		log.WithField("error", err).Error("Failed to convert port to int")
 		return nil, err
 	}
 
-	var config = Config{}
-	config.Prometheus.Port = portInt
-
-	log.WithField("config", config).Info("Loaded configuration file")
+	log.WithField("config", configloader.Summary(config)).Info("Loaded configuration file")
 
 	return &config, nil
 }
+
+// Validate checks the settings the loader cannot check by their type.
+func (c *Config) Validate() []error {
+	problems := []error{}
+
+	_, err := log.ParseLevel(c.Log.Level)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("log.level (LOG_LEVEL): %w", err))
+	}
+
+	err = logging.ValidateFormat(c.Log.Format)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("log.format (LOG_FORMAT): %w", err))
+	}
+
+	err = c.Tracing.Validate()
+	if err != nil {
+		problems = append(problems, fmt.Errorf("tracing (TRACING_EXPORTER, TRACING_SAMPLE_RATIO): %w", err))
+	}
+
+	err = c.Admin.Validate()
+	if err != nil {
+		problems = append(problems, fmt.Errorf("admin (ADMIN_PORT, ADMIN_TOKEN): %w", err))
+	}
+
+	return problems
+}
@@ -1,20 +1,34 @@
 package handler
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	_config "github.com/pavr1/people_project/prometheus/config"
+	"github.com/pavr1/people_project/shared/logging"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
 )
 
+// otherLabel replaces the routes past the configured maximum and statuses that are not HTTP statuses.
+const otherLabel = "other"
+
 type PrometheusHandler struct {
 	log            *log.Logger
+	token          string
+	maxRoutes      int
 	totalRequests  *prometheus.CounterVec
 	responseStatus *prometheus.CounterVec
 	httpDuration   *prometheus.HistogramVec
+
+	mu     sync.Mutex
+	routes map[string]bool
 }
 
 func (h *PrometheusHandler) init() {
@@ -23,7 +37,7 @@ func (h *PrometheusHandler) init() {
 	prometheus.MustRegister(h.httpDuration)
 }
 
-func NewPrometheusHandler(log *log.Logger) *PrometheusHandler {
+func NewPrometheusHandler(log *log.Logger, config *_config.Config) *PrometheusHandler {
 	totalRequests := promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "http_requests_total",
@@ -47,38 +61,161 @@ func NewPrometheusHandler(log *log.Logger) *PrometheusHandler {
 
 	return &PrometheusHandler{
 		log:            log,
+		token:          config.Ingest.Token.Reveal(),
+		maxRoutes:      config.Ingest.MaxRoutes,
 		totalRequests:  totalRequests,
 		responseStatus: responseStatus,
 		httpDuration:   httpDuration,
+		routes:         map[string]bool{},
 	}
 }
 
+// maxBatchBytes bounds the body of a batch, far above a batch of the default 500 observations
+const maxBatchBytes = 4 << 20
+
+// Observation is a request in a batch pushed by a service.
+type Observation struct {
+	Method          string  `json:"method"`
+	Route           string  `json:"route"`
+	Status          int     `json:"status"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// RequestID identifies the request in the logs of the service it was served by
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Batch is the body of a POST, a GET reports a single request in its headers.
+type Batch struct {
+	Observations []Observation `json:"observations"`
+}
+
 func (h *PrometheusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		h.serveBatch(w, r)
+
+		return
+	}
+
+	logger := logging.FromContext(r.Context(), h.log)
+
 	path := r.Header.Get("X-Request-Path")
 	status := r.Header.Get("X-Response-Status")
 	time := r.Header.Get("X-Response-Time")
 
 	if path != "" {
-		log.WithField("path", path).Info("PrometheusMiddleware Executing")
+		logger.WithField("route", path).Info("PrometheusMiddleware Executing")
+		path = h.route(path)
 		h.totalRequests.WithLabelValues(path).Inc()
 	}
 
 	if status != "" {
-		log.WithField("status", status).Info("PrometheusMiddleware Executing")
-		h.responseStatus.WithLabelValues(status).Inc()
+		logger.WithField("status", status).Info("PrometheusMiddleware Executing")
+		code, _ := strconv.Atoi(status)
+		h.responseStatus.WithLabelValues(statusLabel(code)).Inc()
 	}
 
 	if time != "" {
-		time = strings.ReplaceAll(time, "s", "")
-		time = strings.ReplaceAll(time, "ms", "")
-		time = strings.ReplaceAll(time, "µs", "")
-		timeF, err := strconv.ParseFloat(time, 64)
+		timeF, err := parseSeconds(time)
 		if err != nil {
-			log.WithError(err).Error("Failed to parse X-Response-Time to float64")
+			logger.WithError(err).Error("Failed to parse X-Response-Time to float64")
 
 		} else {
-			log.WithField("time", time).Info("PrometheusMiddleware Executing")
+			logger.WithField("duration", time).Info("PrometheusMiddleware Executing")
 			h.httpDuration.WithLabelValues(path).Observe(timeF)
 		}
 	}
 }
+
+// parseSeconds reads X-Response-Time, seconds such as 0.0042 or a duration such as 4.2ms.
+func parseSeconds(value string) (float64, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		return seconds, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return duration.Seconds(), nil
+}
+
+func (h *PrometheusHandler) serveBatch(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), h.log)
+
+	var batch Batch
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes)).Decode(&batch)
+	if err != nil {
+		logger.WithError(err).Error("Failed to decode metrics batch")
+		http.Error(w, "invalid metrics batch", http.StatusBadRequest)
+
+		return
+	}
+
+	for _, observation := range batch.Observations {
+		route := h.route(observation.Route)
+		h.totalRequests.WithLabelValues(route).Inc()
+		h.responseStatus.WithLabelValues(statusLabel(observation.Status)).Inc()
+		h.httpDuration.WithLabelValues(route).Observe(observation.DurationSeconds)
+
+		logger.WithFields(log.Fields{
+			"observed_request_id": observation.RequestID,
+			"route":               observation.Route,
+			"status":              observation.Status,
+		}).Trace("Observation ingested")
+	}
+
+	logger.WithField("observations", len(batch.Observations)).Debug("Metrics batch ingested")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// authorize rejects requests without the ingest token, comparing it in constant time.
+func (h *PrometheusHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		logging.FromContext(r.Context(), h.log).Warn("Unauthorized metrics request")
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		http.Error(w, "ingest token required", http.StatusUnauthorized)
+
+		return false
+	}
+
+	return true
+}
+
+// route returns the label of a pushed route. Only route templates such as /person/{id} are
+// expected, the routes first seen once maxRoutes are labelled share the other label.
+func (h *PrometheusHandler) route(route string) string {
+	if !strings.HasPrefix(route, "/") {
+		return otherLabel
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.routes[route] {
+		return route
+	}
+
+	if len(h.routes) >= h.maxRoutes {
+		return otherLabel
+	}
+
+	h.routes[route] = true
+
+	return route
+}
+
+func statusLabel(status int) string {
+	if status < 100 || status > 599 {
+		return otherLabel
+	}
+
+	return strconv.Itoa(status)
+}
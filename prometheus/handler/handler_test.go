@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_config "github.com/pavr1/people_project/prometheus/config"
+	"github.com/pavr1/people_project/shared/secrets"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+)
+
+// The handler registers its metrics globally, so a single handler serves every case.
+func TestServeBatch(t *testing.T) {
+	config := &_config.Config{}
+	config.Ingest.Token = secrets.New("s3cret")
+	config.Ingest.MaxRoutes = 2
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	h := NewPrometheusHandler(logger, config)
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+		route  string
+		count  float64
+	}{
+		{name: "no token", body: `{"observations": [{"route": "/person/{id}", "status": 200}]}`, status: http.StatusUnauthorized, route: "/person/{id}", count: 0},
+		{name: "wrong token", token: "guess", body: `{"observations": [{"route": "/person/{id}", "status": 200}]}`, status: http.StatusUnauthorized, route: "/person/{id}", count: 0},
+		{name: "first route", token: "s3cret", body: `{"observations": [{"route": "/person/{id}", "status": 200}]}`, status: http.StatusAccepted, route: "/person/{id}", count: 1},
+		{name: "second route", token: "s3cret", body: `{"observations": [{"route": "/person/list", "status": 200}]}`, status: http.StatusAccepted, route: "/person/list", count: 1},
+		{name: "routes past the maximum", token: "s3cret", body: `{"observations": [{"route": "/a", "status": 404}, {"route": "/b", "status": 404}]}`, status: http.StatusAccepted, route: otherLabel, count: 2},
+		{name: "not a route", token: "s3cret", body: `{"observations": [{"route": "x", "status": 200}]}`, status: http.StatusAccepted, route: otherLabel, count: 3},
+		{name: "known route past the maximum", token: "s3cret", body: `{"observations": [{"route": "/person/{id}", "status": 200}]}`, status: http.StatusAccepted, route: "/person/{id}", count: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/prometheus/log", strings.NewReader(test.body))
+			if test.token != "" {
+				r.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("status = %d, want %d", w.Code, test.status)
+			}
+
+			count := testutil.ToFloat64(h.totalRequests.WithLabelValues(test.route))
+			if count != test.count {
+				t.Errorf("requests of %s = %v, want %v", test.route, count, test.count)
+			}
+		})
+	}
+
+	if got := testutil.CollectAndCount(h.totalRequests); got != 3 {
+		t.Errorf("route labels = %d, want 3", got)
+	}
+}
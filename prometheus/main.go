@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"os"
 
 	"github.com/gorilla/mux"
-	"github.com/pavr1/people_project/prometheus/config"
+	_config "github.com/pavr1/people_project/prometheus/config"
 	"github.com/pavr1/people_project/prometheus/handler"
+	"github.com/pavr1/people_project/shared/admin"
+	"github.com/pavr1/people_project/shared/configloader"
+	"github.com/pavr1/people_project/shared/health"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/middleware"
+	_server "github.com/pavr1/people_project/shared/server"
+	"github.com/pavr1/people_project/shared/tracing"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
@@ -16,37 +25,101 @@ import (
 func main() {
 	router := mux.NewRouter()
 
-	log := setupLogger()
+	log, logSettings := setupLogger()
+
+	config, err := _config.NewConfig(os.Args[1:])
+	if errors.Is(err, configloader.ErrPrinted) {
+		return
+	}
 
-	config, err := config.NewConfig()
 	if err != nil {
 		log.WithError(err).Error("Failed to create config")
+		os.Exit(1)
+	}
+
+	logSettings.Configure(config.Log.Level, config.Log.Format, config.Log.ReportCaller)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), log, "prometheus", config.Tracing)
+	if err != nil {
+		log.WithError(err).Error("Failed to set up tracing")
 		return
 	}
 
-	prometheus := handler.NewPrometheusHandler(log)
+	// log.* is reloadable, the rest needs a restart
+	reloader, err := configloader.NewReloader(log, config, configloader.ReloadOptions[_config.Config]{
+		Options: _config.Options(os.Args[1:]),
+		Apply: func(previous *_config.Config, next *_config.Config) {
+			logSettings.Configure(next.Log.Level, next.Log.Format, next.Log.ReportCaller)
+		},
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to create config reloader")
+		return
+	}
+
+	reloadCtx, stopReloading := context.WithCancel(context.Background())
+	go reloader.Run(reloadCtx)
+
+	adminServer := admin.NewServer(log, config.Admin, admin.Options{
+		Service:  "prometheus",
+		Settings: logSettings,
+		Config:   func() interface{} { return reloader.Current() },
+	})
+	go adminServer.Run(reloadCtx)
+
+	// The ingest route is called for every batch of the other services, it is traced but not logged
+	base := middleware.New(middleware.Recover(log))
+	logged := middleware.New(logging.Middleware(log), middleware.Log(log, middleware.Path)).Append(base...)
+	traced := middleware.New(tracing.Middleware(middleware.Path), logging.Middleware(log)).Append(base...)
+
+	prometheus := handler.NewPrometheusHandler(log, config)
 	// Prometheus endpoint
-	router.Path("/prometheus").Handler(promhttp.Handler())
-	router.Path("/prometheus/log").HandlerFunc(prometheus.ServeHTTP)
+	router.Path("/prometheus").Handler(base.Then(promhttp.Handler()))
+	router.Path("/prometheus/log").Handler(traced.ThenFunc(prometheus.ServeHTTP))
+
+	checker := health.NewChecker(log, 0, 0)
+	router.Path("/livez").Handler(base.ThenFunc(checker.Live))
+	router.Path("/readyz").Handler(base.ThenFunc(checker.Ready))
 
 	// Serving static files
-	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
+	router.PathPrefix("/").Handler(logged.Then(http.FileServer(http.Dir("./static/"))))
 
-	log.WithField("Port", config.Prometheus.Port).Info("Serving prometheus requests")
-	log.Error(http.ListenAndServe(fmt.Sprintf(":%d", config.Prometheus.Port), router))
-}
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Prometheus.Port),
+		Handler: router,
+	}
 
-func setupLogger() *log.Logger {
-	logger := log.New()
-	logger.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
+	log.WithField("port", config.Prometheus.Port).Info("Serving prometheus requests")
+	err = _server.Run(log, server, _server.Shutdown{
+		Delay:   config.Shutdown.Delay,
+		Timeout: config.Shutdown.Timeout,
+		Checker: checker,
 	})
+	if err != nil {
+		log.WithError(err).Error("Prometheus server failed")
+	}
+
+	stopReloading()
 
-	logger.SetReportCaller(true)
-	logger.SetLevel(log.DebugLevel)
+	ctx, cancel := context.WithTimeout(context.Background(), config.Shutdown.Timeout)
+	defer cancel()
+
+	err = shutdownTracing(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to flush traces")
+	}
+}
+
+func setupLogger() (*log.Logger, *logging.Settings) {
+	logger := log.New()
+	logger.AddHook(tracing.LogHook{})
 
 	// Set the output to stdout
 	logger.SetOutput(os.Stdout)
 
-	return logger
+	// Until the config is loaded
+	settings := logging.NewSettings(logger)
+	settings.Configure("info", logging.FormatText, true)
+
+	return logger, settings
 }
@@ -2,7 +2,17 @@
 
 # Set the environment variables
 VARIABLES=(
+  "LOG_LEVEL=debug"
+  "LOG_FORMAT=json"
   "PROMETHEUS_PORT=9000"
+  "INGEST_MAX_ROUTES=100"
+  "SHUTDOWN_DELAY=5s"
+  "SHUTDOWN_TIMEOUT=20s"
+  "TRACING_EXPORTER=otlp"
+  "TRACING_ENDPOINT=http://otel-collector:4318"
+  "TRACING_SAMPLE_RATIO=1"
+  "LOG_REPORT_CALLER=true"
+  "ADMIN_PORT=6060"
 )
 
 echo "Setting environment variables in namespace $1"
@@ -11,4 +21,9 @@ for variable in "${VARIABLES[@]}"; do
   kubectl -n $1 set env deployment/prometheus ${variable}
 done
 
+# The admin token is only readable from its secret, see make admin-token
+kubectl -n $1 set env deployment/prometheus --from=secret/prometheus-admin-token
+# The ingest token is shared with the services pushing metrics, see make ingest-token
+kubectl -n $1 set env deployment/prometheus --from=secret/metrics-ingest-token --prefix=INGEST_
+
 echo "Done!"
\ No newline at end of file
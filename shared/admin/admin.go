@@ -0,0 +1,286 @@
+// Package admin serves the diagnostics of a service on a listener of its own, on a port that is
+// not exposed like the service port, and only to callers presenting the admin token:
+//
+//	GET  /admin/buildinfo   version, commit and Go version
+//	GET  /admin/config      effective configuration, secrets redacted
+//	GET  /admin/loglevel    level and package levels
+//	PUT  /admin/loglevel    {"level": "debug"} or {"package": "github.com/...", "level": "debug"}
+//	GET  /admin/goroutines  stacks of every goroutine
+//	GET  /debug/pprof/      pprof profiles
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/pavr1/people_project/shared/configloader"
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/middleware"
+	"github.com/pavr1/people_project/shared/secrets"
+	log "github.com/sirupsen/logrus"
+)
+
+// Version and Commit are set when building a release, e.g.
+// go build -ldflags "-X github.com/pavr1/people_project/shared/admin.Version=1.4.0". The commit
+// defaults to the VCS revision Go stamps into the binary.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// Config is the admin section of the service configurations.
+type Config struct {
+	// Port of the admin listener, 0 disables it
+	Port int `mapstructure:"port"`
+	// Token must be sent as a bearer token with every admin request
+	Token secrets.Secret `mapstructure:"token"`
+}
+
+// Validate checks the settings the loader cannot check by their type.
+func (c Config) Validate() error {
+	if c.Port < 0 {
+		return errors.New("port must not be negative")
+	}
+
+	if c.Port != 0 && c.Token.IsZero() {
+		return errors.New("token is required with port")
+	}
+
+	return nil
+}
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Service    string `json:"service"`
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	Modified   bool   `json:"modified,omitempty"`
+	GoVersion  string `json:"go_version"`
+}
+
+// ReadBuildInfo returns the build info of service.
+func ReadBuildInfo(service string) BuildInfo {
+	info := BuildInfo{
+		Service:   service,
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			info.CommitTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+
+	return info
+}
+
+// Options are what the admin endpoints report on and change.
+type Options struct {
+	Service string
+	// Settings are changed by PUT /admin/loglevel
+	Settings *logging.Settings
+	// Config returns the effective configuration, e.g. the current one of a configloader.Reloader
+	Config func() interface{}
+}
+
+type Server struct {
+	log     *log.Logger
+	config  Config
+	options Options
+	server  *http.Server
+}
+
+// NewServer returns the admin server, Run serves it unless the admin port is 0.
+func NewServer(log *log.Logger, config Config, options Options) *Server {
+	s := &Server{
+		log:     log,
+		config:  config,
+		options: options,
+	}
+
+	// Every admin request is logged, whether it was authorized or not
+	chain := middleware.New(middleware.Recover(log), logging.Middleware(log), middleware.Log(log, middleware.Path), s.authorize)
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/buildinfo", chain.ThenFunc(s.serveBuildInfo))
+	mux.Handle("/admin/config", chain.ThenFunc(s.serveConfig))
+	mux.Handle("/admin/loglevel", chain.ThenFunc(s.serveLogLevel))
+	mux.Handle("/admin/goroutines", chain.ThenFunc(s.serveGoroutines))
+	mux.Handle("/debug/pprof/", chain.ThenFunc(pprof.Index))
+	mux.Handle("/debug/pprof/cmdline", chain.ThenFunc(pprof.Cmdline))
+	mux.Handle("/debug/pprof/profile", chain.ThenFunc(pprof.Profile))
+	mux.Handle("/debug/pprof/symbol", chain.ThenFunc(pprof.Symbol))
+	mux.Handle("/debug/pprof/trace", chain.ThenFunc(pprof.Trace))
+
+	s.server = &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.Port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+// Run serves the admin endpoints until ctx is done.
+func (s *Server) Run(ctx context.Context) {
+	if s.config.Port == 0 {
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		s.server.Shutdown(shutdownCtx)
+	}()
+
+	s.log.WithField("port", s.config.Port).Info("Serving admin endpoints")
+
+	err := s.server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.log.WithError(err).Error("Admin server failed")
+	}
+}
+
+// authorize rejects requests without the admin token, comparing it in constant time.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token.Reveal())) != 1 {
+			logging.FromContext(r.Context(), s.log).Warn("Unauthorized admin request")
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "admin token required", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) serveBuildInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	writeJSON(w, ReadBuildInfo(s.options.Service))
+}
+
+func (s *Server) serveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	body, err := configloader.Redacted(s.options.Config())
+	if err != nil {
+		logging.FromContext(r.Context(), s.log).WithError(err).Error("Failed to render configuration")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(body)
+}
+
+// logLevel is the body of /admin/loglevel. A PUT with a package changes that package only, an
+// empty level removes its level.
+type logLevel struct {
+	Level    string            `json:"level"`
+	Package  string            `json:"package,omitempty"`
+	Packages map[string]string `json:"packages,omitempty"`
+}
+
+func (s *Server) serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var change logLevel
+
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&change)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if change.Package != "" {
+			err = s.options.Settings.SetPackageLevel(change.Package, change.Level)
+		} else {
+			err = s.options.Settings.SetLevel(change.Level)
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		logging.FromContext(r.Context(), s.log).WithFields(log.Fields{"package": change.Package, "log_level": change.Level}).Warn("Log level changed")
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	level, packages := s.options.Settings.Levels()
+
+	writeJSON(w, logLevel{Level: level, Packages: packages})
+}
+
+func (s *Server) serveGoroutines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	// Debug level 2 prints every stack as a panic would
+	runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
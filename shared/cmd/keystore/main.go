@@ -0,0 +1,77 @@
+// keystore manages the encrypted local keystore of package secrets.
+//
+//	keystore keygen               prints a new key for SECRETS_KEYSTORE_KEY
+//	keystore set NAME < value     stores the secret NAME read from stdin
+//	keystore delete NAME          removes the secret NAME
+//
+// set and delete work on the keystore SECRETS_KEYSTORE names, with the key from
+// SECRETS_KEYSTORE_KEY or the file SECRETS_KEYSTORE_KEY_FILE.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pavr1/people_project/shared/secrets"
+)
+
+func main() {
+	err := run(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "keystore:", err)
+
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 1 && args[0] == "keygen" {
+		key, err := secrets.NewKey()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(key.Reveal())
+
+		return nil
+	}
+
+	if len(args) != 2 || (args[0] != "set" && args[0] != "delete") {
+		return errors.New("usage: keystore keygen | set NAME | delete NAME")
+	}
+
+	path := os.Getenv("SECRETS_KEYSTORE")
+	if path == "" {
+		return errors.New("SECRETS_KEYSTORE is not set")
+	}
+
+	resolver := secrets.NewResolver(secrets.FileProvider{LookupEnv: os.LookupEnv}, secrets.EnvProvider{LookupEnv: os.LookupEnv})
+
+	key, ok, err := resolver.Lookup("SECRETS_KEYSTORE_KEY")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errors.New("SECRETS_KEYSTORE_KEY or SECRETS_KEYSTORE_KEY_FILE is not set")
+	}
+
+	keystore, err := secrets.OpenKeystore(path, key)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "delete" {
+		return keystore.Delete(args[1])
+	}
+
+	value, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	return keystore.Set(args[1], secrets.New(strings.TrimRight(string(value), "\r\n")))
+}
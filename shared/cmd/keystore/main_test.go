@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pavr1/people_project/shared/secrets"
+)
+
+// withStdin runs f with value on the standard input.
+func withStdin(t *testing.T, value string, f func()) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = w.WriteString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Close()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		r.Close()
+	}()
+
+	f()
+}
+
+func TestRunSetAndDelete(t *testing.T) {
+	key, err := secrets.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	t.Setenv("SECRETS_KEYSTORE", path)
+	t.Setenv("SECRETS_KEYSTORE_KEY", key.Reveal())
+
+	withStdin(t, "hunter2\n", func() {
+		err = run([]string{"set", "MONGODB_PASSWORD"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystore, err := secrets.OpenKeystore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, ok, err := keystore.Lookup("MONGODB_PASSWORD")
+	if err != nil || !ok || secret.Reveal() != "hunter2" {
+		t.Fatalf("Lookup = %q, %v, %v, want hunter2 without the newline", secret.Reveal(), ok, err)
+	}
+
+	err = run([]string{"delete", "MONGODB_PASSWORD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystore, err = secrets.OpenKeystore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok, err = keystore.Lookup("MONGODB_PASSWORD")
+	if err != nil || ok {
+		t.Fatalf("Lookup after delete = %v, %v, want no secret", ok, err)
+	}
+}
+
+func TestRunRejects(t *testing.T) {
+	key, err := secrets.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		keystore bool
+		key      string
+	}{
+		{name: "unknown command", args: []string{"get", "NAME"}, keystore: true, key: key.Reveal()},
+		{name: "missing name", args: []string{"set"}, keystore: true, key: key.Reveal()},
+		{name: "no keystore", args: []string{"delete", "NAME"}, key: key.Reveal()},
+		{name: "no key", args: []string{"delete", "NAME"}, keystore: true},
+		{name: "invalid key", args: []string{"delete", "NAME"}, keystore: true, key: "not a key"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SECRETS_KEYSTORE", "")
+			if test.keystore {
+				t.Setenv("SECRETS_KEYSTORE", filepath.Join(t.TempDir(), "keystore.json"))
+			}
+
+			t.Setenv("SECRETS_KEYSTORE_KEY_FILE", "")
+			os.Unsetenv("SECRETS_KEYSTORE_KEY_FILE")
+			t.Setenv("SECRETS_KEYSTORE_KEY", test.key)
+			if test.key == "" {
+				os.Unsetenv("SECRETS_KEYSTORE_KEY")
+			}
+
+			err := run(test.args)
+			if err == nil {
+				t.Fatal("run succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,531 @@
+// Package configloader fills the mapstructure-tagged config structs of the services from, in
+// increasing precedence: `default` tags, a YAML or TOML file, environment variables and command
+// line flags.
+//
+// A setting is addressed by its dotted mapstructure path, e.g. mongodb.uri. Its environment
+// variable is the path in upper case joined by underscores (MONGODB_URI) unless an `env` tag names
+// another one, and its flag is the path itself (--mongodb.uri). The file is given by --config or
+// CONFIG_FILE, its keys are the same paths nested.
+//
+// Settings of type secrets.Secret come from the secret providers instead of plain environment
+// variables, so a mounted file named by NAME_FILE or the encrypted keystore can hold them, see
+// package secrets. They are redacted wherever the config is printed.
+//
+// Settings tagged `reload:"true"` may change while the service runs, see Reloader. The others
+// only take effect on restart.
+//
+// Every problem, unparsable values, missing `validate:"required"` settings, unknown file keys and
+// the rules of the config's own Validate method, is reported together in one ValidationError.
+package configloader
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pavr1/people_project/shared/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrPrinted is returned by Load after --help or --print-config of a valid configuration printed
+// their output, the caller exits without starting.
+var ErrPrinted = errors.New("configuration printed")
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	secretType   = reflect.TypeOf(secrets.Secret{})
+)
+
+// Validator is implemented by configs with rules spanning several settings.
+type Validator interface {
+	Validate() []error
+}
+
+// ValidationError lists every problem found while loading.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+type Options struct {
+	// Name is the program name shown in the flag usage
+	Name string
+	// Args are the command line arguments without the program name
+	Args []string
+	// LookupEnv reads environment variables, os.LookupEnv when nil
+	LookupEnv func(string) (string, bool)
+	// Output receives --help and --print-config, os.Stdout when nil
+	Output io.Writer
+	// Secrets resolves secrets.Secret settings, secrets.FromEnv of LookupEnv when nil
+	Secrets *secrets.Resolver
+}
+
+type setting struct {
+	path     string
+	env      string
+	value    reflect.Value
+	def      string
+	hasDef   bool
+	required bool
+	positive bool
+	reload   bool
+	secret   string
+}
+
+// Load fills target, a pointer to a config struct, and validates it.
+func Load(target interface{}, options Options) error {
+	root := reflect.ValueOf(target)
+	if root.Kind() != reflect.Pointer || root.Elem().Kind() != reflect.Struct {
+		return errors.New("configloader: target must be a pointer to a struct")
+	}
+
+	output := options.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	settings := collect(root.Elem(), "")
+
+	flags, err := parseFlags(settings, options)
+	if errors.Is(err, flag.ErrHelp) {
+		flags.set.SetOutput(output)
+		flags.set.PrintDefaults()
+
+		return ErrPrinted
+	}
+
+	if err != nil {
+		return err
+	}
+
+	sources, problems, err := fill(target, settings, flags, options)
+	if err != nil {
+		return err
+	}
+
+	if flags.printConfig {
+		data, err := render(root.Elem(), "", sources)
+		if err != nil {
+			return err
+		}
+
+		output.Write(data)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	if flags.printConfig {
+		return ErrPrinted
+	}
+
+	return nil
+}
+
+type parsedFlags struct {
+	set         *flag.FlagSet
+	configFile  string
+	printConfig bool
+	values      map[string]*flagValue
+}
+
+// parseFlags parses the command line flags of the settings and finds the config file.
+func parseFlags(settings []setting, options Options) (*parsedFlags, error) {
+	flags := &parsedFlags{
+		set:    flag.NewFlagSet(options.Name, flag.ContinueOnError),
+		values: map[string]*flagValue{},
+	}
+
+	flags.set.SetOutput(io.Discard)
+	flags.set.StringVar(&flags.configFile, "config", "", "YAML or TOML configuration file, CONFIG_FILE")
+	flags.set.BoolVar(&flags.printConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+
+	for _, s := range settings {
+		value := &flagValue{isBool: s.value.Kind() == reflect.Bool}
+		flags.values[s.path] = value
+
+		usage := "env " + s.env
+		if s.value.Type() == secretType {
+			usage += " or " + s.env + "_FILE"
+		}
+		if s.hasDef {
+			usage += ", default " + strconv.Quote(s.def)
+		}
+
+		if s.reload {
+			usage += ", reloadable"
+		}
+
+		flags.set.Var(value, s.path, usage)
+	}
+
+	err := flags.set.Parse(options.Args)
+	if err != nil {
+		return flags, err
+	}
+
+	if flags.set.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.set.Arg(0))
+	}
+
+	if flags.configFile == "" {
+		flags.configFile, _ = lookupEnv(options)("CONFIG_FILE")
+	}
+
+	return flags, nil
+}
+
+// fill sets every setting from its layers and returns where each value came from and the
+// problems found. The error is for the file that cannot be read.
+func fill(target interface{}, settings []setting, flags *parsedFlags, options Options) (map[string]string, []string, error) {
+	lookupEnv := lookupEnv(options)
+
+	resolver := options.Secrets
+	if resolver == nil {
+		var err error
+
+		resolver, err = secrets.FromEnv(lookupEnv)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	fileValues := map[string]string{}
+	if flags.configFile != "" {
+		values, err := readFile(flags.configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fileValues = values
+	}
+
+	problems := []string{}
+	sources := map[string]string{}
+
+	for _, s := range settings {
+		raw, source, found := s.def, "default", s.hasDef
+
+		if value, ok := fileValues[s.path]; ok {
+			raw, source, found = value, "file", true
+			delete(fileValues, s.path)
+		}
+
+		if s.value.Type() == secretType {
+			secret, ok, err := resolver.Lookup(s.env)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s (%s): %v", s.path, s.env, err))
+
+				continue
+			}
+
+			if ok {
+				raw, source, found = secret.Reveal(), "secret "+s.env, true
+			}
+		} else if value, ok := lookupEnv(s.env); ok && value != "" {
+			raw, source, found = value, "env "+s.env, true
+		}
+
+		if value := flags.values[s.path]; value.set {
+			raw, source, found = value.value, "flag --"+s.path, true
+		}
+
+		if !found {
+			if s.required {
+				problems = append(problems, fmt.Sprintf("%s (%s) is required", s.path, s.env))
+			}
+
+			continue
+		}
+
+		sources[s.path] = source
+
+		err := set(s.value, raw)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", s.path, source, err))
+
+			continue
+		}
+
+		if s.required && s.value.IsZero() {
+			problems = append(problems, fmt.Sprintf("%s (%s) is required", s.path, s.env))
+		}
+
+		if s.positive && !isPositive(s.value) {
+			problems = append(problems, fmt.Sprintf("%s (%s) must be positive, got %s", s.path, source, raw))
+		}
+	}
+
+	unknown := []string{}
+	for path := range fileValues {
+		unknown = append(unknown, path)
+	}
+
+	sort.Strings(unknown)
+	for _, path := range unknown {
+		problems = append(problems, fmt.Sprintf("%s in %s is not a known setting", path, flags.configFile))
+	}
+
+	if validator, ok := target.(Validator); ok {
+		for _, err := range validator.Validate() {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	return sources, problems, nil
+}
+
+func lookupEnv(options Options) func(string) (string, bool) {
+	if options.LookupEnv == nil {
+		return os.LookupEnv
+	}
+
+	return options.LookupEnv
+}
+
+// collect returns the settings of the struct v, depth first in declaration order.
+func collect(v reflect.Value, prefix string) []setting {
+	settings := []setting{}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := key(field)
+		if name == "-" {
+			continue
+		}
+
+		path := join(prefix, name)
+		value := v.Field(i)
+
+		if isGroup(value) {
+			settings = append(settings, collect(value, path)...)
+
+			continue
+		}
+
+		s := setting{
+			path:   path,
+			env:    strings.ToUpper(strings.ReplaceAll(path, ".", "_")),
+			value:  value,
+			secret: field.Tag.Get("secret"),
+		}
+
+		if env, ok := field.Tag.Lookup("env"); ok {
+			s.env = env
+		}
+
+		s.def, s.hasDef = field.Tag.Lookup("default")
+		s.reload = field.Tag.Get("reload") == "true"
+
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			switch rule {
+			case "required":
+				s.required = true
+			case "positive":
+				s.positive = true
+			}
+		}
+
+		settings = append(settings, s)
+	}
+
+	return settings
+}
+
+// isGroup tells whether v is a struct of settings rather than a setting.
+func isGroup(v reflect.Value) bool {
+	return v.Kind() == reflect.Struct && v.Type() != durationType && v.Type() != secretType
+}
+
+func key(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+
+	return name
+}
+
+func join(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + "." + name
+}
+
+// set parses raw into v. Slices are comma separated.
+func set(v reflect.Value, raw string) error {
+	if v.Type() == secretType {
+		v.Set(reflect.ValueOf(secrets.New(raw)))
+
+		return nil
+	}
+
+	if v.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 5s", raw)
+		}
+
+		v.SetInt(int64(duration))
+
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+
+		v.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+
+		v.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a non negative integer", raw)
+		}
+
+		v.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+
+		v.SetFloat(parsed)
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), 0, 0)
+		if strings.TrimSpace(raw) != "" {
+			for _, item := range strings.Split(raw, ",") {
+				element := reflect.New(v.Type().Elem()).Elem()
+
+				err := set(element, strings.TrimSpace(item))
+				if err != nil {
+					return err
+				}
+
+				slice = reflect.Append(slice, element)
+			}
+		}
+
+		v.Set(slice)
+	default:
+		return fmt.Errorf("settings of type %s are not supported", v.Type())
+	}
+
+	return nil
+}
+
+func isPositive(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() > 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() > 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() > 0
+	}
+
+	return true
+}
+
+// readFile flattens a YAML or TOML file into setting paths and their raw values.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	document := map[string]interface{}{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &document)
+	case ".toml":
+		err = toml.Unmarshal(data, &document)
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", document, values)
+
+	return values, nil
+}
+
+func flatten(prefix string, value interface{}, values map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, item := range v {
+			flatten(join(prefix, name), item, values)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+
+		values[prefix] = strings.Join(items, ",")
+	case nil:
+		values[prefix] = ""
+	default:
+		values[prefix] = fmt.Sprint(v)
+	}
+}
+
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+
+	return f.value
+}
+
+func (f *flagValue) Set(value string) error {
+	f.value = value
+	f.set = true
+
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
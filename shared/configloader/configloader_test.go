@@ -0,0 +1,230 @@
+package configloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pavr1/people_project/shared/secrets"
+)
+
+type testConfig struct {
+	Server struct {
+		Port int    `mapstructure:"port" default:"8080" validate:"positive"`
+		Host string `mapstructure:"host" validate:"required"`
+	} `mapstructure:"server"`
+	Timeouts struct {
+		Read time.Duration `mapstructure:"read" env:"READ_TIMEOUT" default:"5s"`
+	} `mapstructure:"timeouts"`
+	Push    bool           `mapstructure:"push"`
+	Ratio   float64        `mapstructure:"ratio" default:"0.5"`
+	Retries uint8          `mapstructure:"retries" default:"2"`
+	Buckets []int32        `mapstructure:"buckets" default:"0,18,65"`
+	Fields  []string       `mapstructure:"fields"`
+	Token   secrets.Secret `mapstructure:"token"`
+	Min     int            `mapstructure:"min"`
+	Max     int            `mapstructure:"max" default:"10"`
+	// Skipped is not a setting, its type would not be supported otherwise
+	Skipped map[string]bool `mapstructure:"-"`
+}
+
+func (c *testConfig) Validate() []error {
+	if c.Min > c.Max {
+		return []error{errors.New("min (MIN) must not exceed max")}
+	}
+
+	return nil
+}
+
+// load loads a testConfig from the env map, the file named and written with content when set,
+// and args.
+func load(t *testing.T, env map[string]string, file string, content string, args ...string) (*testConfig, error) {
+	t.Helper()
+
+	if file != "" {
+		path := filepath.Join(t.TempDir(), file)
+
+		err := os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args = append([]string{"--config", path}, args...)
+	}
+
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+
+		return value, ok
+	}
+
+	config := &testConfig{}
+	err := Load(config, Options{Name: "test", Args: args, LookupEnv: lookupEnv, Secrets: secrets.NewResolver(secrets.EnvProvider{LookupEnv: lookupEnv})})
+
+	return config, err
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		file    string
+		content string
+		args    []string
+		port    int
+	}{
+		{name: "default", port: 8080},
+		{name: "yaml file over default", file: "config.yaml", content: "server:\n  port: 8081\n", port: 8081},
+		{name: "toml file over default", file: "config.toml", content: "[server]\nport = 8081\n", port: 8081},
+		{name: "env over file", env: map[string]string{"SERVER_PORT": "8082"}, file: "config.yaml", content: "server:\n  port: 8081\n", port: 8082},
+		{name: "empty env is unset", env: map[string]string{"SERVER_PORT": ""}, file: "config.yaml", content: "server:\n  port: 8081\n", port: 8081},
+		{name: "flag over env", env: map[string]string{"SERVER_PORT": "8082"}, file: "config.yaml", content: "server:\n  port: 8081\n", args: []string{"--server.port", "8083"}, port: 8083},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{"SERVER_HOST": "localhost"}
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			config, err := load(t, env, test.file, test.content, test.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Server.Port != test.port {
+				t.Errorf("server.port = %d, want %d", config.Server.Port, test.port)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	err := os.WriteFile(path, []byte("server:\n  port: 8081\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := load(t, map[string]string{"SERVER_HOST": "localhost", "CONFIG_FILE": path}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Server.Port != 8081 {
+		t.Errorf("server.port = %d, want 8081 from CONFIG_FILE", config.Server.Port)
+	}
+}
+
+func TestLoadTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		file    string
+		content string
+		get     func(c *testConfig) interface{}
+		want    interface{}
+	}{
+		{name: "duration default", get: func(c *testConfig) interface{} { return c.Timeouts.Read }, want: 5 * time.Second},
+		{name: "duration from env tag", env: map[string]string{"READ_TIMEOUT": "1m30s"}, get: func(c *testConfig) interface{} { return c.Timeouts.Read }, want: 90 * time.Second},
+		{name: "bool", env: map[string]string{"PUSH": "true"}, get: func(c *testConfig) interface{} { return c.Push }, want: true},
+		{name: "float", env: map[string]string{"RATIO": "0.25"}, get: func(c *testConfig) interface{} { return c.Ratio }, want: 0.25},
+		{name: "unsigned", env: map[string]string{"RETRIES": "7"}, get: func(c *testConfig) interface{} { return c.Retries }, want: uint8(7)},
+		{name: "slice default", get: func(c *testConfig) interface{} { return c.Buckets }, want: []int32{0, 18, 65}},
+		{name: "slice from env", env: map[string]string{"BUCKETS": "1, 2 ,3"}, get: func(c *testConfig) interface{} { return c.Buckets }, want: []int32{1, 2, 3}},
+		{name: "slice from yaml list", file: "config.yaml", content: "fields: [name, lastName]\n", get: func(c *testConfig) interface{} { return c.Fields }, want: []string{"name", "lastName"}},
+		{name: "empty yaml value", file: "config.yaml", content: "fields:\n", get: func(c *testConfig) interface{} { return c.Fields }, want: []string{}},
+		{name: "secret", env: map[string]string{"TOKEN": "s3cret"}, get: func(c *testConfig) interface{} { return c.Token.Reveal() }, want: "s3cret"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{"SERVER_HOST": "localhost"}
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			config, err := load(t, env, test.file, test.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := test.get(config)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadCollectsEveryProblem(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		file     string
+		content  string
+		problems []string
+	}{
+		{
+			name:     "missing required setting",
+			problems: []string{"server.host (SERVER_HOST) is required"},
+		},
+		{
+			name: "every kind of problem at once",
+			env: map[string]string{
+				"SERVER_PORT":  "0",
+				"READ_TIMEOUT": "5",
+				"PUSH":         "yes",
+				"RETRIES":      "-1",
+				"BUCKETS":      "1,x",
+				"MIN":          "11",
+			},
+			file:    "config.yaml",
+			content: "server:\n  prot: 8081\n",
+			problems: []string{
+				"server.port (env SERVER_PORT) must be positive, got 0",
+				"server.host (SERVER_HOST) is required",
+				`timeouts.read (env READ_TIMEOUT): "5" is not a duration such as 5s`,
+				`push (env PUSH): "yes" is not true or false`,
+				`retries (env RETRIES): "-1" is not a non negative integer`,
+				`buckets (env BUCKETS): "x" is not an integer`,
+				"server.prot in ",
+				"min (MIN) must not exceed max",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := load(t, test.env, test.file, test.content)
+
+			validationErr := &ValidationError{}
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("error = %v, want a ValidationError", err)
+			}
+
+			if len(validationErr.Problems) != len(test.problems) {
+				t.Fatalf("problems = %q, want %d of them", validationErr.Problems, len(test.problems))
+			}
+
+			for i, problem := range test.problems {
+				if !strings.HasPrefix(validationErr.Problems[i], problem) {
+					t.Errorf("problem %d = %q, want it to start with %q", i, validationErr.Problems[i], problem)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownFileFormat(t *testing.T) {
+	_, err := load(t, map[string]string{"SERVER_HOST": "localhost"}, "config.json", "{}")
+	if err == nil || !strings.Contains(err.Error(), "must be .yaml, .yml or .toml") {
+		t.Errorf("error = %v, want the supported formats", err)
+	}
+}
@@ -0,0 +1,123 @@
+package configloader
+
+import (
+	"bytes"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted is shown instead of the value of secrets.Secret and `secret:"true"` settings, settings
+// tagged `secret:"url"` only have the password of the URL hidden.
+const redacted = "REDACTED"
+
+// Redacted returns config, a config struct or a pointer to one, as YAML with its secrets redacted.
+func Redacted(config interface{}) ([]byte, error) {
+	return render(reflect.Indirect(reflect.ValueOf(config)), "", nil)
+}
+
+// Summary returns config with its secrets redacted on one line, for logs.
+func Summary(config interface{}) string {
+	mapping, err := node(reflect.Indirect(reflect.ValueOf(config)), "", nil)
+	if err != nil {
+		return err.Error()
+	}
+
+	mapping.Style = yaml.FlowStyle
+
+	data, err := yaml.Marshal(mapping)
+	if err != nil {
+		return err.Error()
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// render encodes v as YAML, commenting each setting with where its value came from.
+func render(v reflect.Value, prefix string, sources map[string]string) ([]byte, error) {
+	document := &yaml.Node{Kind: yaml.DocumentNode}
+
+	mapping, err := node(v, prefix, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	document.Content = []*yaml.Node{mapping}
+
+	buffer := bytes.Buffer{}
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+
+	err = encoder.Encode(document)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func node(v reflect.Value, prefix string, sources map[string]string) (*yaml.Node, error) {
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := key(field)
+		if name == "-" {
+			continue
+		}
+
+		path := join(prefix, name)
+		value := v.Field(i)
+
+		child := &yaml.Node{}
+		if isGroup(value) {
+			nested, err := node(value, path, sources)
+			if err != nil {
+				return nil, err
+			}
+
+			child = nested
+		} else {
+			err := child.Encode(printable(value, field.Tag.Get("secret")))
+			if err != nil {
+				return nil, err
+			}
+
+			if child.Kind == yaml.SequenceNode {
+				child.Style = yaml.FlowStyle
+			}
+
+			child.LineComment = sources[path]
+		}
+
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, child)
+	}
+
+	return mapping, nil
+}
+
+func printable(v reflect.Value, secret string) interface{} {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+
+	if secret == "" || v.IsZero() {
+		return v.Interface()
+	}
+
+	if secret == "url" && v.Kind() == reflect.String {
+		parsed, err := url.Parse(v.String())
+		if err == nil {
+			return parsed.Redacted()
+		}
+	}
+
+	return redacted
+}
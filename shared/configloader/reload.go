@@ -0,0 +1,225 @@
+package configloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+type ReloadOptions[T any] struct {
+	// Options are the options the current config was loaded with
+	Options Options
+	// Interval between checks of the config file for changes, 5s when zero
+	Interval time.Duration
+	// Apply puts the reloadable settings of next into effect. It runs once next is valid and must
+	// not fail, settings that can be invalid are checked by the config's Validate.
+	Apply func(previous *T, next *T)
+	// Registerer receives the reload metrics, prometheus.DefaultRegisterer when nil
+	Registerer prometheus.Registerer
+}
+
+// Reloader reloads a config on SIGHUP and whenever its config file changes. The environment and
+// flags of a running process do not change, so new values come from the file.
+//
+// A reload that fails to load or validate keeps the previous config. Restart-only settings keep
+// their value as well, their change is logged and applied by the next restart.
+type Reloader[T any] struct {
+	log     *log.Logger
+	options ReloadOptions[T]
+	current atomic.Pointer[T]
+
+	mu       sync.Mutex
+	file     string
+	checksum []byte
+
+	reloads    *prometheus.CounterVec
+	successful prometheus.Gauge
+	lastReload prometheus.Gauge
+}
+
+// NewReloader watches the config current, loaded by Load with options.Options.
+func NewReloader[T any](log *log.Logger, current *T, options ReloadOptions[T]) (*Reloader[T], error) {
+	if options.Interval == 0 {
+		options.Interval = 5 * time.Second
+	}
+
+	if options.Registerer == nil {
+		options.Registerer = prometheus.DefaultRegisterer
+	}
+
+	flags, err := parseFlags(collect(reflect.ValueOf(new(T)).Elem(), ""), options.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reloader[T]{
+		log:     log,
+		options: options,
+		file:    flags.configFile,
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Configuration reloads by result.",
+		}, []string{"result"}),
+		successful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "config_last_reload_successful",
+			Help: "Whether the last configuration reload succeeded.",
+		}),
+		lastReload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "config_last_reload_success_timestamp_seconds",
+			Help: "Time of the last successful configuration reload.",
+		}),
+	}
+
+	r.current.Store(current)
+	r.checksum, _ = r.fileChecksum()
+	r.successful.Set(1)
+	r.lastReload.SetToCurrentTime()
+
+	for _, collector := range []prometheus.Collector{r.reloads, r.successful, r.lastReload} {
+		err := options.Registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Current returns the config in effect. It is never modified, a reload replaces it.
+func (r *Reloader[T]) Current() *T {
+	return r.current.Load()
+}
+
+// Run reloads on SIGHUP and file changes until ctx is done.
+func (r *Reloader[T]) Run(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	ticker := time.NewTicker(r.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			r.Reload("SIGHUP")
+		case <-ticker.C:
+			if r.fileChanged() {
+				r.Reload("file change")
+			}
+		}
+	}
+}
+
+// Reload loads the config again and, if it is valid, applies it.
+func (r *Reloader[T]) Reload(trigger string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logger := r.log.WithField("trigger", trigger)
+
+	// Remembered before loading, a change while loading triggers another reload
+	r.checksum, _ = r.fileChecksum()
+
+	next := new(T)
+
+	err := Load(next, r.options.Options)
+	if errors.Is(err, ErrPrinted) {
+		err = nil
+	}
+
+	if err != nil {
+		logger.WithError(err).Error("Configuration reload failed, keeping the previous configuration")
+		r.reloads.WithLabelValues("failure").Inc()
+		r.successful.Set(0)
+
+		return err
+	}
+
+	previous := r.current.Load()
+	changed, restartOnly := diff(reflect.ValueOf(previous).Elem(), reflect.ValueOf(next).Elem())
+
+	if len(restartOnly) > 0 {
+		logger.WithField("settings", restartOnly).Warn("Changed settings need a restart to take effect")
+	}
+
+	r.current.Store(next)
+	if len(changed) > 0 {
+		r.options.Apply(previous, next)
+	}
+
+	logger.WithField("settings", changed).Info("Configuration reloaded")
+	r.reloads.WithLabelValues("success").Inc()
+	r.successful.Set(1)
+	r.lastReload.SetToCurrentTime()
+
+	return nil
+}
+
+// diff returns the changed reloadable settings and the changed restart-only ones, which get
+// their previous value back in next.
+func diff(previous reflect.Value, next reflect.Value) ([]string, []string) {
+	changed := []string{}
+	restartOnly := []string{}
+
+	previousSettings := collect(previous, "")
+	for i, s := range collect(next, "") {
+		old := previousSettings[i].value
+		if reflect.DeepEqual(old.Interface(), s.value.Interface()) {
+			continue
+		}
+
+		if !s.reload {
+			s.value.Set(old)
+			restartOnly = append(restartOnly, s.path)
+
+			continue
+		}
+
+		changed = append(changed, s.path)
+	}
+
+	return changed, restartOnly
+}
+
+func (r *Reloader[T]) fileChanged() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == "" {
+		return false
+	}
+
+	// An unreadable file, e.g. while a ConfigMap is swapped, is retried on the next tick
+	checksum, err := r.fileChecksum()
+
+	return err == nil && !bytes.Equal(checksum, r.checksum)
+}
+
+func (r *Reloader[T]) fileChecksum() ([]byte, error) {
+	if r.file == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(r.file)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return sum[:], nil
+}
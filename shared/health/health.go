@@ -0,0 +1,180 @@
+// Package health serves the liveness and readiness probes of the services.
+//
+// /livez only tells whether the process serves requests. /readyz runs the dependency checks,
+// caching each result so frequent probes do not load the dependencies, and fails as soon as the
+// service starts shutting down.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   Check
+
+	mu      sync.Mutex
+	err     error
+	checked time.Time
+}
+
+type Checker struct {
+	log          *log.Logger
+	ttl          time.Duration
+	timeout      time.Duration
+	checks       []*check
+	shuttingDown atomic.Bool
+}
+
+// NewChecker caches check results for ttl and gives each check up to timeout.
+func NewChecker(log *log.Logger, ttl time.Duration, timeout time.Duration) *Checker {
+	return &Checker{
+		log:     log,
+		ttl:     ttl,
+		timeout: timeout,
+	}
+}
+
+// Add registers a readiness check. Checks are added before serving.
+func (c *Checker) Add(name string, fn Check) {
+	c.checks = append(c.checks, &check{name: name, fn: fn})
+}
+
+// ShutDown makes readiness fail from now on, so no new traffic is routed to the service.
+func (c *Checker) ShutDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Live answers the liveness probe.
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// Ready answers the readiness probe with the outcome of every check.
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	ready := !c.shuttingDown.Load()
+
+	results := map[string]string{}
+	for _, check := range c.checks {
+		err := c.run(r.Context(), check)
+		if err != nil {
+			ready = false
+			results[check.name] = err.Error()
+
+			continue
+		}
+
+		results[check.name] = "ok"
+	}
+
+	status := "ready"
+	statusCode := http.StatusOK
+
+	if c.shuttingDown.Load() {
+		status = "shutting down"
+		statusCode = http.StatusServiceUnavailable
+	} else if !ready {
+		status = "not ready"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	bytes, err := json.Marshal(struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{status, results})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(bytes)
+}
+
+// run returns the cached result of the check, running it again once the result is older than ttl.
+func (c *Checker) run(ctx context.Context, check *check) error {
+	check.mu.Lock()
+	defer check.mu.Unlock()
+
+	if !check.checked.IsZero() && time.Since(check.checked) < c.ttl {
+		return check.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	err := check.fn(ctx)
+	if err != nil && check.err == nil {
+		c.log.WithError(err).WithField("check", check.name).Warn("Readiness check failed")
+	}
+
+	if err == nil && check.err != nil {
+		c.log.WithField("check", check.name).Info("Readiness check recovered")
+	}
+
+	check.err = err
+	check.checked = time.Now()
+
+	return err
+}
+
+// HTTPCheck checks that url answers with a status below 500.
+func HTTPCheck(client *http.Client, url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return &StatusError{StatusCode: resp.StatusCode}
+		}
+
+		return nil
+	}
+}
+
+// ReadyURL is the /readyz endpoint of the service serving serviceUrl.
+func ReadyURL(serviceUrl string) (string, error) {
+	parsed, err := url.Parse(serviceUrl)
+	if err != nil {
+		return "", err
+	}
+
+	parsed.Path = "/readyz"
+	parsed.RawQuery = ""
+
+	return parsed.String(), nil
+}
+
+// StatusError is returned by HTTPCheck for server error responses.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.StatusCode)
+}
@@ -0,0 +1,113 @@
+package httpclient
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker, exported as the outbound_circuit_state gauge.
+type State int
+
+const (
+	// StateClosed lets every request through
+	StateClosed State = iota
+	// StateHalfOpen lets a single probe through, its outcome closes or reopens the breaker
+	StateHalfOpen
+	// StateOpen rejects requests until the open timeout passed
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	}
+
+	return "closed"
+}
+
+// breaker opens after threshold consecutive failures and probes the dependency again once
+// openTimeout passed.
+type breaker struct {
+	threshold   int
+	openTimeout time.Duration
+	onChange    func(State)
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow tells whether a request may be sent now. An allowed request must report its outcome.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return false
+		}
+
+		b.setState(StateHalfOpen)
+		b.probing = true
+
+		return true
+	case StateHalfOpen:
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+
+		return true
+	}
+
+	return true
+}
+
+// report records the outcome of an allowed request.
+func (b *breaker) report(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateHalfOpen {
+		b.probing = false
+	}
+
+	if success {
+		b.failures = 0
+		b.setState(StateClosed)
+
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(StateOpen)
+	}
+}
+
+// release ends an allowed request without an outcome, e.g. one its caller cancelled.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateHalfOpen {
+		b.probing = false
+	}
+}
+
+func (b *breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+
+	b.state = state
+	b.onChange(state)
+}
@@ -0,0 +1,77 @@
+package httpclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	changes := []State{}
+
+	b := &breaker{
+		threshold:   2,
+		openTimeout: 20 * time.Millisecond,
+		onChange: func(state State) {
+			changes = append(changes, state)
+		},
+	}
+
+	// Each step runs action and expects the breaker in state afterwards, allow steps expect allowed
+	tests := []struct {
+		name    string
+		action  string
+		allowed bool
+		state   State
+	}{
+		{name: "first request", action: "allow", allowed: true, state: StateClosed},
+		{name: "failure below the threshold", action: "fail", state: StateClosed},
+		{name: "second request", action: "allow", allowed: true, state: StateClosed},
+		{name: "failure reaching the threshold", action: "fail", state: StateOpen},
+		{name: "request while open", action: "allow", allowed: false, state: StateOpen},
+		{name: "open timeout", action: "wait", state: StateOpen},
+		{name: "probe", action: "allow", allowed: true, state: StateHalfOpen},
+		{name: "request during the probe", action: "allow", allowed: false, state: StateHalfOpen},
+		{name: "probe cancelled by its caller", action: "release", state: StateHalfOpen},
+		{name: "next probe", action: "allow", allowed: true, state: StateHalfOpen},
+		{name: "probe failing", action: "fail", state: StateOpen},
+		{name: "request after the failed probe", action: "allow", allowed: false, state: StateOpen},
+		{name: "second open timeout", action: "wait", state: StateOpen},
+		{name: "last probe", action: "allow", allowed: true, state: StateHalfOpen},
+		{name: "probe succeeding", action: "succeed", state: StateClosed},
+		{name: "request after closing", action: "allow", allowed: true, state: StateClosed},
+		{name: "failure after closing", action: "fail", state: StateClosed},
+	}
+
+	for _, test := range tests {
+		switch test.action {
+		case "allow":
+			allowed := b.allow()
+			if allowed != test.allowed {
+				t.Fatalf("%s: allow() = %v, want %v", test.name, allowed, test.allowed)
+			}
+		case "fail":
+			b.report(false)
+		case "succeed":
+			b.report(true)
+		case "release":
+			b.release()
+		case "wait":
+			time.Sleep(b.openTimeout + 10*time.Millisecond)
+		}
+
+		if b.state != test.state {
+			t.Fatalf("%s: state = %s, want %s", test.name, b.state, test.state)
+		}
+	}
+
+	want := []State{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("changes = %v, want %v", changes, want)
+		}
+	}
+}
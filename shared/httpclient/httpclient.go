@@ -0,0 +1,252 @@
+// Package httpclient builds the HTTP clients the services call their dependencies with.
+//
+// Every attempt is bounded by a timeout. Idempotent requests are retried with jittered
+// exponential backoff after transport errors and 502, 503 and 504 responses. A circuit breaker
+// per dependency stops calling it after consecutive failures, failing fast with ErrCircuitOpen,
+// and lets a single probe through once its open timeout passed. Each call is a client span, its
+// trace context and request ID are sent with every attempt.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// ErrCircuitOpen is returned without calling the dependency while its breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Policy is how a dependency is called, it is embedded in the service configs.
+type Policy struct {
+	// Retries are the attempts after the first one, for idempotent requests only
+	Retries int `mapstructure:"retries" default:"2"`
+	// Backoff is the delay before the first retry, doubled for each further one
+	Backoff time.Duration `mapstructure:"backoff" default:"100ms"`
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration `mapstructure:"max_backoff" default:"1s"`
+	// FailureThreshold is the number of consecutive failures that opens the breaker
+	FailureThreshold int `mapstructure:"failure_threshold" default:"5" validate:"positive"`
+	// OpenTimeout is how long the open breaker rejects requests before probing
+	OpenTimeout time.Duration `mapstructure:"open_timeout" default:"30s" validate:"positive"`
+}
+
+// Metrics are shared by the clients of a service, labelled by dependency.
+type Metrics struct {
+	state       *prometheus.GaugeVec
+	transitions *prometheus.CounterVec
+	requests    *prometheus.CounterVec
+	retries     *prometheus.CounterVec
+}
+
+// NewMetrics registers the client metrics with registerer, prometheus.DefaultRegisterer when nil.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := &Metrics{
+		state: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "outbound_circuit_state",
+			Help: "Circuit breaker state by dependency: 0 closed, 1 half-open, 2 open.",
+		}, []string{"dependency"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "outbound_circuit_transitions_total",
+			Help: "Circuit breaker state changes by dependency and new state.",
+		}, []string{"dependency", "state"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "outbound_requests_total",
+			Help: "Outbound request attempts by dependency and result: success, failure or rejected.",
+		}, []string{"dependency", "result"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "outbound_retries_total",
+			Help: "Outbound request retries by dependency.",
+		}, []string{"dependency"}),
+	}
+
+	for _, collector := range []prometheus.Collector{m.state, m.transitions, m.requests, m.retries} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+type transport struct {
+	name    string
+	timeout time.Duration
+	policy  Policy
+	metrics *Metrics
+	breaker *breaker
+	next    http.RoundTripper
+}
+
+// New returns a client for the dependency name, each attempt bounded by timeout.
+func New(log *log.Logger, name string, timeout time.Duration, policy Policy, metrics *Metrics) *http.Client {
+	t := &transport{
+		name:    name,
+		timeout: timeout,
+		policy:  policy,
+		metrics: metrics,
+		next:    http.DefaultTransport,
+	}
+
+	t.breaker = &breaker{
+		threshold:   policy.FailureThreshold,
+		openTimeout: policy.OpenTimeout,
+		onChange: func(state State) {
+			log.WithField("dependency", name).WithField("state", state.String()).Warn("Circuit breaker state changed")
+			metrics.state.WithLabelValues(name).Set(float64(state))
+			metrics.transitions.WithLabelValues(name, state.String()).Inc()
+		},
+	}
+
+	metrics.state.WithLabelValues(name).Set(float64(StateClosed))
+
+	traced := otelhttp.NewTransport(t, otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+		return name + " " + req.Method
+	}))
+
+	return &http.Client{Transport: traced}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The dependency logs the call under the ID of the request it is made for
+	id := logging.RequestID(req.Context())
+	if id != "" && req.Header.Get(logging.RequestIDHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+
+	attempts := 1
+	if isIdempotent(req) {
+		attempts += t.policy.Retries
+	}
+
+	backoff := t.policy.Backoff
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(req)
+		if attempt == attempts || !isRetryable(req, resp, err) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		t.metrics.retries.WithLabelValues(t.name).Inc()
+
+		// Full jitter keeps the retries of concurrent requests apart
+		delay := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		backoff = min(backoff*2, t.policy.MaxBackoff)
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// attempt sends req once, if the breaker allows it.
+func (t *transport) attempt(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		t.metrics.requests.WithLabelValues(t.name, "rejected").Inc()
+
+		return nil, fmt.Errorf("%s: %w", t.name, ErrCircuitOpen)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+
+	// The caller cancelling is not the dependency failing
+	failed := (err != nil && req.Context().Err() == nil) || (resp != nil && resp.StatusCode >= http.StatusInternalServerError)
+	if err != nil && !failed {
+		t.breaker.release()
+	} else {
+		t.breaker.report(!failed)
+	}
+
+	if failed {
+		t.metrics.requests.WithLabelValues(t.name, "failure").Inc()
+	} else if err == nil {
+		t.metrics.requests.WithLabelValues(t.name, "success").Inc()
+	}
+
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// isIdempotent tells whether req may be sent more than once.
+func isIdempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func isRetryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// cancelBody releases the attempt timeout once the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
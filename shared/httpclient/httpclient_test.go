@@ -0,0 +1,198 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+func newTestClient(t *testing.T, policy Policy) *http.Client {
+	t.Helper()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(logger, "test", time.Second, policy, metrics)
+}
+
+func TestRoundTripRetries(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		replayable     bool
+		idempotencyKey string
+		statuses       []int
+		attempts       int
+		status         int
+	}{
+		{name: "GET after 503", method: http.MethodGet, statuses: []int{503, 200}, attempts: 2, status: 200},
+		{name: "GET after 502 and 504", method: http.MethodGet, statuses: []int{502, 504, 200}, attempts: 3, status: 200},
+		{name: "GET out of retries", method: http.MethodGet, statuses: []int{503}, attempts: 3, status: 503},
+		{name: "GET after 500", method: http.MethodGet, statuses: []int{500, 200}, attempts: 1, status: 500},
+		{name: "GET after 404", method: http.MethodGet, statuses: []int{404, 200}, attempts: 1, status: 404},
+		{name: "POST after 503", method: http.MethodPost, body: "payload", replayable: true, statuses: []int{503, 200}, attempts: 1, status: 503},
+		{name: "POST with Idempotency-Key", method: http.MethodPost, body: "payload", replayable: true, idempotencyKey: "key", statuses: []int{503, 200}, attempts: 2, status: 200},
+		{name: "PUT with its body replayed", method: http.MethodPut, body: "payload", replayable: true, statuses: []int{502, 503, 200}, attempts: 3, status: 200},
+		{name: "PUT with a body that cannot be replayed", method: http.MethodPut, body: "payload", statuses: []int{503, 200}, attempts: 1, status: 503},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mu := sync.Mutex{}
+			bodies := []string{}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+
+				mu.Lock()
+				bodies = append(bodies, string(body))
+				status := test.statuses[min(len(bodies), len(test.statuses))-1]
+				mu.Unlock()
+
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			client := newTestClient(t, Policy{Retries: 2, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, FailureThreshold: 100, OpenTimeout: time.Minute})
+
+			var body io.Reader
+			if test.body != "" {
+				body = strings.NewReader(test.body)
+				if !test.replayable {
+					// Hides the type http.NewRequest sets GetBody for
+					body = struct{ io.Reader }{body}
+				}
+			}
+
+			req, err := http.NewRequest(test.method, server.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.idempotencyKey != "" {
+				req.Header.Set("Idempotency-Key", test.idempotencyKey)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp.Body.Close()
+
+			if resp.StatusCode != test.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, test.status)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if len(bodies) != test.attempts {
+				t.Fatalf("attempts = %d, want %d", len(bodies), test.attempts)
+			}
+
+			for i, received := range bodies {
+				if received != test.body {
+					t.Errorf("attempt %d body = %q, want %q", i+1, received, test.body)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTripOpensBreaker(t *testing.T) {
+	attempts := atomic.Int32{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, Policy{FailureThreshold: 2, OpenTimeout: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+	}
+
+	_, err := client.Get(server.URL)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+
+	if attempts.Load() != 2 {
+		t.Errorf("attempts = %d, want 2", attempts.Load())
+	}
+}
+
+func TestRoundTripCallerCancellationIsNoFailure(t *testing.T) {
+	attempts := atomic.Int32{}
+	arrived := make(chan struct{}, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+
+		if r.URL.Path == "/slow" {
+			arrived <- struct{}{}
+			<-r.Context().Done()
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// A single failure would open the breaker
+	client := newTestClient(t, Policy{Retries: 2, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, FailureThreshold: 1, OpenTimeout: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-arrived
+		cancel()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Do(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request after the cancellation: %v", err)
+	}
+
+	resp.Body.Close()
+
+	if attempts.Load() != 2 {
+		t.Errorf("attempts = %d, want the cancelled one not retried", attempts.Load())
+	}
+}
@@ -0,0 +1,147 @@
+// Package logging formats the service logs and carries a logger per request.
+//
+// Middleware accepts the X-Request-ID of the caller or generates one, returns it in the response
+// and puts a logger with the request_id field in the request context. Code serving the request
+// logs through FromContext, so every entry of a request, down to the repository, can be found by
+// its ID:
+//
+//	logging.FromContext(ctx, r.log).WithError(err).Error("Failed to find document in MongoDB")
+//
+// Clients of httpclient send the request ID to the services they call.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader carries the request ID between the services and back to the caller.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs accepted from callers
+const maxRequestIDLength = 128
+
+// Formats
+const (
+	// FormatText is logfmt-like text for people reading the logs
+	FormatText = "text"
+	// FormatJSON is a JSON object per line for log pipelines
+	FormatJSON = "json"
+)
+
+// Field names of the entries of every service, set by the shared middlewares and this package.
+const (
+	FieldRequestID = "request_id"
+	FieldTraceID   = "trace_id"
+	FieldSpanID    = "span_id"
+	FieldTime      = "time"
+	FieldLevel     = "level"
+	FieldMessage   = "message"
+	FieldFunc      = "caller"
+	FieldFile      = "file"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+)
+
+// ValidateFormat checks that format is text or json.
+func ValidateFormat(format string) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("must be %s or %s", FormatText, FormatJSON)
+	}
+
+	return nil
+}
+
+// newFormatter returns the formatter of format, it was validated when the config was loaded.
+func newFormatter(format string) log.Formatter {
+	if format == FormatJSON {
+		return &log.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+			FieldMap: log.FieldMap{
+				log.FieldKeyTime:  FieldTime,
+				log.FieldKeyLevel: FieldLevel,
+				log.FieldKeyMsg:   FieldMessage,
+				log.FieldKeyFunc:  FieldFunc,
+				log.FieldKeyFile:  FieldFile,
+			},
+		}
+	}
+
+	return &log.TextFormatter{
+		FullTimestamp: true,
+		FieldMap: log.FieldMap{
+			log.FieldKeyMsg:  FieldMessage,
+			log.FieldKeyFunc: FieldFunc,
+		},
+	}
+}
+
+// Middleware puts the request ID and the request logger in the request context. The chain must
+// apply it before middleware.Log so the request log carries the ID.
+func Middleware(log *log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+
+			w.Header().Set(RequestIDHeader, id)
+
+			ctx := context.WithValue(r.Context(), requestIDKey, id)
+			ctx = context.WithValue(ctx, loggerKey, log.WithField(FieldRequestID, id))
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestID is the ID of the request ctx belongs to, empty outside of requests.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+
+	return id
+}
+
+// FromContext returns the logger of the request ctx belongs to, fallback outside of requests.
+// The entries carry ctx for hooks such as tracing.LogHook.
+func FromContext(ctx context.Context, fallback *log.Logger) *log.Entry {
+	if entry, ok := ctx.Value(loggerKey).(*log.Entry); ok {
+		return entry.WithContext(ctx)
+	}
+
+	return fallback.WithContext(ctx)
+}
+
+// validRequestID accepts IDs of visible ASCII characters only, they end up in logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,197 @@
+package logging
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Settings are the runtime settings of a logger: its format, whether entries report their caller
+// and its level, optionally per package of the code logging, e.g. debug for the repository while
+// the rest stays at info. Package levels need the caller of each entry, callers are reported
+// while there are any.
+type Settings struct {
+	logger *log.Logger
+	// updating orders the changes of the logger
+	updating sync.Mutex
+
+	mu           sync.RWMutex
+	formatter    log.Formatter
+	reportCaller bool
+	level        log.Level
+	packages     map[string]log.Level
+}
+
+// NewSettings takes over the format and level of logger, which must not be changed by other means
+// afterwards.
+func NewSettings(logger *log.Logger) *Settings {
+	s := &Settings{
+		logger:    logger,
+		formatter: newFormatter(FormatText),
+		level:     logger.GetLevel(),
+		packages:  map[string]log.Level{},
+	}
+
+	logger.SetFormatter(&levelFilter{settings: s})
+
+	return s
+}
+
+// Configure applies the log section of a configuration, validated when it was loaded. Package
+// levels set at runtime are kept.
+func (s *Settings) Configure(level string, format string, reportCaller bool) {
+	parsed, err := log.ParseLevel(level)
+	if err != nil {
+		s.logger.WithError(err).Error("Invalid log level")
+
+		return
+	}
+
+	s.update(func() error {
+		s.formatter = newFormatter(format)
+		s.reportCaller = reportCaller
+		s.level = parsed
+
+		return nil
+	})
+}
+
+// SetLevel changes the level of the packages without a level of their own.
+func (s *Settings) SetLevel(level string) error {
+	parsed, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func() error {
+		s.level = parsed
+
+		return nil
+	})
+}
+
+// SetPackageLevel changes the level of the entries logged by pkg and the packages below it, e.g.
+// github.com/pavr1/people_project/people/handlers. An empty level removes the package level.
+func (s *Settings) SetPackageLevel(pkg string, level string) error {
+	pkg = strings.TrimSuffix(pkg, "/")
+	if pkg == "" {
+		return errors.New("package is required")
+	}
+
+	if level == "" {
+		return s.update(func() error {
+			delete(s.packages, pkg)
+
+			return nil
+		})
+	}
+
+	parsed, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func() error {
+		s.packages[pkg] = parsed
+
+		return nil
+	})
+}
+
+// Levels returns the level and the package levels.
+func (s *Settings) Levels() (string, map[string]string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	packages := map[string]string{}
+	for pkg, level := range s.packages {
+		packages[pkg] = level.String()
+	}
+
+	return s.level.String(), packages
+}
+
+// update changes the settings and lets the logger create the entries of the most verbose level,
+// levelFilter drops those of packages at a lower one. The logger is changed once s.mu is
+// released, it formats entries while holding a lock of its own.
+func (s *Settings) update(change func() error) error {
+	s.updating.Lock()
+	defer s.updating.Unlock()
+
+	s.mu.Lock()
+	err := change()
+
+	verbose := s.level
+	for _, level := range s.packages {
+		if level > verbose {
+			verbose = level
+		}
+	}
+
+	reportCaller := s.reportCaller || len(s.packages) > 0
+	s.mu.Unlock()
+
+	s.logger.SetLevel(verbose)
+	s.logger.SetReportCaller(reportCaller)
+
+	return err
+}
+
+// levelOf returns the level of the package that logged the entry, the longest matching package
+// wins. s.mu must be held.
+func (s *Settings) levelOf(entry *log.Entry) log.Level {
+	if len(s.packages) == 0 || entry.Caller == nil {
+		return s.level
+	}
+
+	pkg := packageOf(entry.Caller.Function)
+
+	matches := []string{}
+	for candidate := range s.packages {
+		if pkg == candidate || strings.HasPrefix(pkg, candidate+"/") {
+			matches = append(matches, candidate)
+		}
+	}
+
+	if len(matches) == 0 {
+		return s.level
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return len(matches[i]) > len(matches[j]) })
+
+	return s.packages[matches[0]]
+}
+
+// packageOf returns the package of a function name such as
+// github.com/pavr1/people_project/people/handlers/repo.(*RepoHandler).GetPerson.
+func packageOf(function string) string {
+	slash := strings.LastIndex(function, "/")
+	dot := strings.Index(function[slash+1:], ".")
+	if dot < 0 {
+		return function
+	}
+
+	return function[:slash+1+dot]
+}
+
+// levelFilter formats the entries of the level of their package with the configured formatter.
+// Dropped entries are formatted as nothing, logrus writes the empty result.
+type levelFilter struct {
+	settings *Settings
+}
+
+func (f *levelFilter) Format(entry *log.Entry) ([]byte, error) {
+	f.settings.mu.RLock()
+	level := f.settings.levelOf(entry)
+	formatter := f.settings.formatter
+	f.settings.mu.RUnlock()
+
+	if entry.Level > level {
+		return nil, nil
+	}
+
+	return formatter.Format(entry)
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the request metrics of a service, labelled by method, route and status.
+type Metrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+}
+
+// NewMetrics registers the request metrics with registerer.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Served requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Request latency by method, route and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Requests being served by method and route.",
+		}, []string{"method", "route"}),
+	}
+
+	for _, collector := range []prometheus.Collector{m.requests, m.duration, m.inFlight} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Middleware records the requests of the route. The route must be known before the handler
+// runs, which holds for chains built per route.
+func (m *Metrics) Middleware(route RouteFunc) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := route(r)
+			start := time.Now()
+			rw := NewResponseWriter(w)
+
+			method := methodLabel(r.Method)
+
+			inFlight := m.inFlight.WithLabelValues(method, name)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			next.ServeHTTP(rw, r)
+
+			status := strconv.Itoa(rw.Status())
+			m.requests.WithLabelValues(method, name, status).Inc()
+			m.duration.WithLabelValues(method, name, status).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// methodLabel bounds the method label, clients can send any token as method.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+
+	return "other"
+}
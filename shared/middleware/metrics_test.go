@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsBoundMethodLabel(t *testing.T) {
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := metrics.Middleware(Path)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete, "get", "PROPFIND", "X-RANDOM-1", "X-RANDOM-2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/person/list", nil))
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		count  float64
+	}{
+		{name: "GET", method: http.MethodGet, count: 1},
+		{name: "DELETE", method: http.MethodDelete, count: 1},
+		{name: "unknown methods", method: "other", count: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count := testutil.ToFloat64(metrics.requests.WithLabelValues(test.method, "/person/list", "200"))
+			if count != test.count {
+				t.Errorf("requests = %v, want %v", count, test.count)
+			}
+		})
+	}
+
+	series := testutil.CollectAndCount(metrics.requests)
+	if series != len(tests) {
+		t.Errorf("series = %d, want %d", series, len(tests))
+	}
+}
@@ -0,0 +1,205 @@
+// Package middleware is the HTTP middleware chain of the services.
+//
+// A Chain is built per route, so each route gets the middlewares it needs, e.g. request logging
+// for the API routes but not for the probes. Log and Observe come before Recover, so a recovered
+// panic is logged and observed as the 500 it turns into. logging.Middleware comes before Log, so
+// the request log carries the request ID:
+//
+//	api := middleware.New(logging.Middleware(log), middleware.Log(log, route), middleware.Observe(route, observer), middleware.Recover(log))
+//	router.Handle("/person/list", api.ThenFunc(handler.GetPersonList))
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/pavr1/people_project/shared/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+type Middleware func(http.Handler) http.Handler
+
+// Chain applies its middlewares in order, the first one outermost.
+type Chain []Middleware
+
+func New(middlewares ...Middleware) Chain {
+	return append(Chain{}, middlewares...)
+}
+
+// Append returns a new chain with middlewares applied after those of c.
+func (c Chain) Append(middlewares ...Middleware) Chain {
+	return append(append(Chain{}, c...), middlewares...)
+}
+
+func (c Chain) Then(handler http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		handler = c[i](handler)
+	}
+
+	return handler
+}
+
+func (c Chain) ThenFunc(handler http.HandlerFunc) http.Handler {
+	return c.Then(handler)
+}
+
+// RouteFunc names the route of a request for logs and metrics, e.g. its path template, so
+// requests for different IDs count as one route.
+type RouteFunc func(r *http.Request) string
+
+// Path is the RouteFunc of routes without path parameters.
+func Path(r *http.Request) string {
+	return r.URL.Path
+}
+
+// Observation describes a served request.
+type Observation struct {
+	Method   string
+	Route    string
+	Status   int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Observer records an observation, e.g. as metrics. It runs after the response was written.
+type Observer func(r *http.Request, observation Observation)
+
+// Observe passes an observation of every request to observer.
+func Observe(route RouteFunc, observer Observer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := NewResponseWriter(w)
+
+			next.ServeHTTP(rw, r)
+
+			observer(r, Observation{
+				Method:   r.Method,
+				Route:    route(r),
+				Status:   rw.Status(),
+				Bytes:    rw.Bytes(),
+				Duration: time.Since(start),
+			})
+		})
+	}
+}
+
+// Log logs every request once it is served with the request logger, server errors at error level.
+func Log(log *log.Logger, route RouteFunc) Middleware {
+	return Observe(route, func(r *http.Request, observation Observation) {
+		logger := logging.FromContext(r.Context(), log).WithFields(map[string]interface{}{
+			"method":   observation.Method,
+			"route":    observation.Route,
+			"status":   observation.Status,
+			"bytes":    observation.Bytes,
+			"duration": observation.Duration.String(),
+		})
+
+		if observation.Status >= http.StatusInternalServerError {
+			logger.Error("Request failed")
+
+			return
+		}
+
+		logger.Info("Request served")
+	})
+}
+
+// Recover turns a panicking handler into a 500 response, unless the response was started.
+func Recover(log *log.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := NewResponseWriter(w)
+
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+
+				// Aborting the response on purpose is not a failure
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				logging.FromContext(r.Context(), log).WithField("panic", recovered).WithField("stack", string(debug.Stack())).Error("Handler panicked")
+
+				if !rw.Written() {
+					http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// ResponseWriter records the status and size of the response.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// NewResponseWriter wraps w, a writer that already is a ResponseWriter is returned as it is so
+// every middleware sees the same response.
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	if rw, ok := w.(*ResponseWriter); ok {
+		return rw
+	}
+
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+func (rw *ResponseWriter) WriteHeader(status int) {
+	// Informational responses such as 103 Early Hints precede the actual status
+	if rw.status == 0 && status >= http.StatusOK {
+		rw.status = status
+	}
+
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *ResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+
+	return n, err
+}
+
+// Flush supports streaming responses.
+func (rw *ResponseWriter) Flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer.
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Status is the response status, 200 for a handler that wrote nothing.
+func (rw *ResponseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+
+	return rw.status
+}
+
+func (rw *ResponseWriter) Bytes() int64 {
+	return rw.bytes
+}
+
+// Written tells whether the response was started.
+func (rw *ResponseWriter) Written() bool {
+	return rw.status != 0
+}
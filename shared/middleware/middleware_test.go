@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestResponseWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter)
+		status  int
+		bytes   int64
+		written bool
+	}{
+		{name: "nothing written", handler: func(w http.ResponseWriter) {}, status: http.StatusOK},
+		{name: "explicit status", handler: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("not found"))
+		}, status: http.StatusNotFound, bytes: 9, written: true},
+		{name: "implicit 200 from Write", handler: func(w http.ResponseWriter) {
+			w.Write([]byte("hello"))
+			w.Write([]byte(", world"))
+		}, status: http.StatusOK, bytes: 12, written: true},
+		{name: "status written twice", handler: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, status: http.StatusCreated, written: true},
+		{name: "status after Write", handler: func(w http.ResponseWriter) {
+			w.Write([]byte("ok"))
+			w.WriteHeader(http.StatusInternalServerError)
+		}, status: http.StatusOK, bytes: 2, written: true},
+		{name: "informational status ignored", handler: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusAccepted)
+		}, status: http.StatusAccepted, written: true},
+		{name: "informational status only", handler: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+		}, status: http.StatusOK},
+		{name: "flush", handler: func(w http.ResponseWriter) {
+			w.(http.Flusher).Flush()
+		}, status: http.StatusOK, written: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := NewResponseWriter(httptest.NewRecorder())
+			test.handler(rw)
+
+			if rw.Status() != test.status {
+				t.Errorf("Status() = %d, want %d", rw.Status(), test.status)
+			}
+
+			if rw.Bytes() != test.bytes {
+				t.Errorf("Bytes() = %d, want %d", rw.Bytes(), test.bytes)
+			}
+
+			if rw.Written() != test.written {
+				t.Errorf("Written() = %v, want %v", rw.Written(), test.written)
+			}
+		})
+	}
+}
+
+func TestNewResponseWriterReusesWrapper(t *testing.T) {
+	rw := NewResponseWriter(httptest.NewRecorder())
+	if NewResponseWriter(rw) != rw {
+		t.Fatal("NewResponseWriter wrapped a ResponseWriter again")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		body    string
+	}{
+		{name: "no panic", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, status: http.StatusNoContent},
+		{name: "panic before writing", handler: func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}, status: http.StatusInternalServerError, body: "Internal Server Error\n"},
+		{name: "panic after the status", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("boom")
+		}, status: http.StatusAccepted},
+		{name: "panic after the body", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			panic("boom")
+		}, status: http.StatusOK, body: "partial"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Recover(logger)(test.handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+
+			if w.Body.String() != test.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), test.body)
+			}
+		})
+	}
+}
+
+func TestRecoverRepanicsOnAbort(t *testing.T) {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler", recovered)
+		}
+	}()
+
+	handler := Recover(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,214 @@
+// Package openapi serves the OpenAPI specification of a service with its documentation page and
+// validates the requests, and in strict mode the responses, of the routes it describes:
+//
+//	openApiHandler, err := openapi.NewHandler(log, openapi.Options{Title: "Auth API", Spec: spec, Strict: config.OpenApi.Strict})
+//	api := middleware.New(..., openApiHandler.Middleware)
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/pavr1/people_project/shared/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed docs.html
+var docsTemplate string
+
+var docs = template.Must(template.New("docs").Parse(docsTemplate))
+
+// Options describe the API of a service.
+type Options struct {
+	// Title of the documentation page
+	Title string
+	// Spec is the OpenAPI 3 document, YAML or JSON
+	Spec []byte
+	// Strict validates the responses as well, responses not matching the specification are
+	// replaced by a 500
+	Strict bool
+}
+
+type Handler struct {
+	log    *log.Logger
+	strict bool
+	router routers.Router
+	json   []byte
+	docs   []byte
+}
+
+func NewHandler(log *log.Logger, options Options) (*Handler, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(options.Spec)
+	if err != nil {
+		log.WithError(err).Error("Failed to load OpenAPI specification")
+
+		return nil, err
+	}
+
+	err = doc.Validate(openapi3.NewLoader().Context)
+	if err != nil {
+		log.WithError(err).Error("Invalid OpenAPI specification")
+
+		return nil, err
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		log.WithError(err).Error("Failed to create OpenAPI router")
+
+		return nil, err
+	}
+
+	specJSON, err := json.Marshal(doc)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal OpenAPI specification")
+
+		return nil, err
+	}
+
+	page := bytes.Buffer{}
+
+	err = docs.Execute(&page, options.Title)
+	if err != nil {
+		log.WithError(err).Error("Failed to render OpenAPI documentation")
+
+		return nil, err
+	}
+
+	return &Handler{
+		log:    log,
+		strict: options.Strict,
+		router: router,
+		json:   specJSON,
+		docs:   page.Bytes(),
+	}, nil
+}
+
+// ServeSpec returns the OpenAPI document as JSON.
+func (h *Handler) ServeSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(h.json)
+}
+
+// ServeDocs returns an HTML page rendering the OpenAPI document.
+func (h *Handler) ServeDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(h.docs)
+}
+
+// Middleware validates incoming requests against the specification and, in strict mode,
+// validates the responses as well. Routes that are not in the specification are passed through.
+func (h *Handler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := h.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				MultiError:         true,
+			},
+		}
+
+		err = openapi3filter.ValidateRequest(r.Context(), input)
+		if err != nil {
+			logging.FromContext(r.Context(), h.log).WithError(err).WithField("path", r.URL.Path).Warn("Request does not match OpenAPI specification")
+
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+
+			return
+		}
+
+		if !h.strict {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		rw := newBufferedResponseWriter()
+		next.ServeHTTP(rw, r)
+
+		err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rw.statusCode,
+			Header:                 rw.header,
+			Body:                   io.NopCloser(bytes.NewReader(rw.body.Bytes())),
+			Options: &openapi3filter.Options{
+				IncludeResponseStatus: true,
+				MultiError:            true,
+			},
+		})
+		if err != nil {
+			logging.FromContext(r.Context(), h.log).WithError(err).WithFields(log.Fields{"path": r.URL.Path, "status": rw.statusCode}).Error("Response does not match OpenAPI specification")
+
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+
+			return
+		}
+
+		rw.flush(w)
+	})
+}
+
+// bufferedResponseWriter holds the response in memory so it can be validated before it is sent.
+type bufferedResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+	written    bool
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: http.Header{}, statusCode: http.StatusOK}
+}
+
+func (rw *bufferedResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if rw.written {
+		return
+	}
+
+	rw.statusCode = statusCode
+	rw.written = true
+}
+
+func (rw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	rw.written = true
+
+	if rw.header.Get("Content-Type") == "" {
+		rw.header.Set("Content-Type", http.DetectContentType(b))
+	}
+
+	return rw.body.Write(b)
+}
+
+func (rw *bufferedResponseWriter) flush(w http.ResponseWriter) {
+	for key, values := range rw.header {
+		w.Header()[key] = values
+	}
+
+	w.WriteHeader(rw.statusCode)
+	w.Write(rw.body.Bytes())
+}
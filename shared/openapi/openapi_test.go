@@ -0,0 +1,109 @@
+package openapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const testSpec = `
+openapi: 3.0.3
+info:
+  title: Test API
+  version: 1.0.0
+paths:
+  /items/{id}:
+    get:
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: integer
+      responses:
+        "200":
+          description: The item
+          content:
+            application/json:
+              schema:
+                type: object
+                required: [id, name]
+                properties:
+                  id:
+                    type: integer
+                  name:
+                    type: string
+`
+
+func newTestHandler(t *testing.T, strict bool) *Handler {
+	t.Helper()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	handler, err := NewHandler(logger, Options{Title: "Test API", Spec: []byte(testSpec), Strict: strict})
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	return handler
+}
+
+func TestMiddleware(t *testing.T) {
+	valid := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1, "name": "one"}`))
+	}
+
+	// The name is missing, the handler drifted from the specification
+	drifted := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1}`))
+	}
+
+	tests := []struct {
+		name    string
+		strict  bool
+		path    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{name: "valid response", strict: true, path: "/items/1", handler: valid, status: http.StatusOK},
+		{name: "drifted response in strict mode", strict: true, path: "/items/1", handler: drifted, status: http.StatusInternalServerError},
+		{name: "drifted response", strict: false, path: "/items/1", handler: drifted, status: http.StatusOK},
+		{name: "invalid request", strict: true, path: "/items/one", handler: valid, status: http.StatusBadRequest},
+		{name: "route not in specification", strict: true, path: "/other", handler: drifted, status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newTestHandler(t, test.strict).Middleware(test.handler)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d, body %q", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeDocs(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestHandler(t, false).ServeDocs(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+	body := w.Body.String()
+
+	if !strings.Contains(body, "<title>Test API</title>") {
+		t.Errorf("docs page lacks the title: %s", body)
+	}
+
+	if strings.Contains(body, "/latest/") {
+		t.Errorf("docs page loads an unpinned redoc version: %s", body)
+	}
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// Backend stores the token buckets. MemoryBackend keeps them in the process; a backend shared
+// by every replica (e.g. Redis) makes the limits apply to the deployment as a whole.
+type Backend interface {
+	// Take removes one token from the bucket of key, creating a full bucket for new keys.
+	Take(key string, limit Limit, now time.Time) Result
+}
+
+// Result is the state of a bucket after a Take.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next token is available, zero when Allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+type bucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryBackend is a Backend local to the process.
+type MemoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// recent orders the buckets from the most to the least recently used
+	recent  *list.List
+	idle    time.Duration
+	maxKeys int
+}
+
+// NewMemoryBackend returns a backend that forgets buckets unused for longer than idle and keeps
+// at most maxKeys of them, evicting the least recently used one to make room for a new key.
+func NewMemoryBackend(idle time.Duration, maxKeys int) *MemoryBackend {
+	return &MemoryBackend{
+		buckets: map[string]*list.Element{},
+		recent:  list.New(),
+		idle:    idle,
+		maxKeys: maxKeys,
+	}
+}
+
+func (m *MemoryBackend) Take(key string, limit Limit, now time.Time) Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+
+	burst := float64(limit.Burst)
+
+	element, ok := m.buckets[key]
+	if ok {
+		m.recent.MoveToFront(element)
+	} else {
+		if m.maxKeys > 0 && m.recent.Len() >= m.maxKeys {
+			// An evicted caller only gets a full bucket back, which an attacker spreading over
+			// many keys could have had anyway
+			m.remove(m.recent.Back())
+		}
+
+		element = m.recent.PushFront(&bucket{key: key, tokens: burst, updated: now})
+		m.buckets[key] = element
+	}
+
+	b := element.Value.(*bucket)
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+
+	result := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((burst - b.tokens) / limit.Rate)
+
+	return result
+}
+
+// Len returns the number of buckets kept.
+func (m *MemoryBackend) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recent.Len()
+}
+
+// sweep drops the buckets idle for longer than idle, starting from the least recently used one; a
+// dropped bucket would be full anyway as long as idle is longer than the time a bucket takes to
+// refill.
+func (m *MemoryBackend) sweep(now time.Time) {
+	for element := m.recent.Back(); element != nil; element = m.recent.Back() {
+		if now.Sub(element.Value.(*bucket).updated) <= m.idle {
+			return
+		}
+
+		m.remove(element)
+	}
+}
+
+func (m *MemoryBackend) remove(element *list.Element) {
+	m.recent.Remove(element)
+	delete(m.buckets, element.Value.(*bucket).key)
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
@@ -0,0 +1,273 @@
+// Package ratelimit is a token bucket rate limiting middleware shared by the services.
+//
+// Every request takes a token from the bucket of its caller on its route. The middleware keys
+// callers by client IP; services verifying a token call Allow with the verified principal instead,
+// so a forged token cannot pick the bucket it is charged to, and AllowClient when it fails. Allowed responses carry
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers; rejected ones get a 429 with
+// Retry-After as well.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pavr1/people_project/shared/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// Limit refills Rate tokens per second up to Burst. A zero Burst disables limiting.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// KeyFunc identifies the caller of a request.
+type KeyFunc func(r *http.Request) string
+
+// RouteFunc identifies the route of a request, matching the keys of Options.Routes.
+type RouteFunc func(r *http.Request) string
+
+type Options struct {
+	Default Limit
+	Routes  map[string]Limit
+	// Key defaults to the client IP
+	Key   KeyFunc
+	Route RouteFunc
+	// TrustedProxies are the peers whose X-Forwarded-For header is believed, e.g. the ingress
+	TrustedProxies []*net.IPNet
+}
+
+type Limiter struct {
+	log     *log.Logger
+	backend Backend
+	options Options
+	// limits holds the default and route limits, replaced as a whole by SetLimits
+	limits atomic.Pointer[limits]
+}
+
+type limits struct {
+	fallback Limit
+	routes   map[string]Limit
+}
+
+func NewLimiter(log *log.Logger, backend Backend, options Options) *Limiter {
+	if options.Key == nil {
+		options.Key = func(r *http.Request) string {
+			return "ip:" + ClientIP(r, options.TrustedProxies)
+		}
+	}
+
+	if options.Route == nil {
+		options.Route = func(r *http.Request) string {
+			return r.URL.Path
+		}
+	}
+
+	limiter := &Limiter{
+		log:     log,
+		backend: backend,
+		options: options,
+	}
+
+	limiter.SetLimits(options.Default, options.Routes)
+
+	return limiter
+}
+
+// SetLimits replaces the default and route limits, e.g. after a configuration reload. Buckets
+// already filled keep their tokens.
+func (l *Limiter) SetLimits(defaultLimit Limit, routes map[string]Limit) {
+	l.limits.Store(&limits{fallback: defaultLimit, routes: routes})
+}
+
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Allow(w, r, l.options.Key(r)) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllowClient is Allow with the key the middleware uses, the client IP unless Options.Key is set.
+// Services verifying tokens charge it for requests whose token fails verification.
+func (l *Limiter) AllowClient(w http.ResponseWriter, r *http.Request) bool {
+	return l.Allow(w, r, l.options.Key(r))
+}
+
+// Allow takes a token from the bucket of key on the route of r and sets the rate limit headers.
+// It answers 429 and returns false when the bucket is empty.
+func (l *Limiter) Allow(w http.ResponseWriter, r *http.Request, key string) bool {
+	route := l.options.Route(r)
+	current := l.limits.Load()
+
+	limit, ok := current.routes[route]
+	if !ok {
+		limit = current.fallback
+	}
+
+	if limit.Burst <= 0 {
+		return true
+	}
+
+	result := l.backend.Take(route+"|"+key, limit, time.Now())
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+
+	if !result.Allowed {
+		logging.FromContext(r.Context(), l.log).WithFields(log.Fields{"route": route, "key": key}).Warn("Rate limit exceeded")
+
+		w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("Too many requests"))
+
+		return false
+	}
+
+	return true
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// ClientIP returns the peer address of r. When the peer is one of the trusted proxies, the
+// X-Forwarded-For addresses are walked from the nearest hop and the first untrusted one is
+// returned, so a client cannot choose its address by sending the header itself.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !isTrusted(host, trusted) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+
+		if net.ParseIP(hop) == nil {
+			// a malformed hop was not added by a trusted proxy
+			return host
+		}
+
+		host = hop
+
+		if !isTrusted(hop, trusted) {
+			return hop
+		}
+	}
+
+	return host
+}
+
+func isTrusted(address string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseCIDRs parses comma separated CIDRs, e.g. "10.0.0.0/8,fd00::/8".
+func ParseCIDRs(value string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	if value == "" {
+		return networks, nil
+	}
+
+	for _, cidr := range strings.Split(value, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q must be a CIDR", cidr)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// ParseLimit parses "rate:burst", e.g. "10:20" for 10 requests per second with bursts of 20.
+func ParseLimit(value string) (Limit, error) {
+	rate, burst, ok := strings.Cut(value, ":")
+	if !ok {
+		return Limit{}, fmt.Errorf("limit %q must be rate:burst", value)
+	}
+
+	rateFloat, err := strconv.ParseFloat(rate, 64)
+	if err != nil || rateFloat < 0 {
+		return Limit{}, fmt.Errorf("limit %q has an invalid rate", value)
+	}
+
+	burstInt, err := strconv.Atoi(burst)
+	if err != nil || burstInt < 0 {
+		return Limit{}, fmt.Errorf("limit %q has an invalid burst", value)
+	}
+
+	if burstInt > 0 && rateFloat == 0 {
+		return Limit{}, fmt.Errorf("limit %q needs a rate above 0", value)
+	}
+
+	return Limit{Rate: rateFloat, Burst: burstInt}, nil
+}
+
+// ParseRoutes parses comma separated route=rate:burst pairs, e.g. "/person/create=1:5,/livez=0:0".
+func ParseRoutes(value string) (map[string]Limit, error) {
+	routes := map[string]Limit{}
+	if value == "" {
+		return routes, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		route, limit, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("route limit %q must be route=rate:burst", pair)
+		}
+
+		parsed, err := ParseLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", route, err)
+		}
+
+		routes[route] = parsed
+	}
+
+	return routes, nil
+}
+
+// ParseOptions builds Options from a default "rate:burst" limit and ParseRoutes route limits.
+func ParseOptions(defaultLimit string, routes string) (Options, error) {
+	limit, err := ParseLimit(defaultLimit)
+	if err != nil {
+		return Options{}, err
+	}
+
+	routeLimits, err := ParseRoutes(routes)
+	if err != nil {
+		return Options{}, err
+	}
+
+	return Options{Default: limit, Routes: routeLimits}, nil
+}
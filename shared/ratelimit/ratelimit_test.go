@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseCIDRs("10.0.0.0/8, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		ip        string
+	}{
+		{name: "direct client", peer: "203.0.113.7:4000", ip: "203.0.113.7"},
+		{name: "untrusted peer sending the header", peer: "203.0.113.7:4000", forwarded: []string{"198.51.100.1"}, ip: "203.0.113.7"},
+		{name: "trusted proxy", peer: "10.0.0.2:4000", forwarded: []string{"198.51.100.1"}, ip: "198.51.100.1"},
+		{name: "client prepending a forged hop", peer: "10.0.0.2:4000", forwarded: []string{"192.0.2.9, 198.51.100.1"}, ip: "198.51.100.1"},
+		{name: "chain of trusted proxies", peer: "10.0.0.2:4000", forwarded: []string{"198.51.100.1, 10.0.0.3", "10.0.0.4"}, ip: "198.51.100.1"},
+		{name: "only trusted hops", peer: "10.0.0.2:4000", forwarded: []string{"10.0.0.3"}, ip: "10.0.0.3"},
+		{name: "malformed hop", peer: "10.0.0.2:4000", forwarded: []string{"198.51.100.1, bogus"}, ip: "10.0.0.2"},
+		{name: "trusted IPv6 proxy", peer: "[fd00::1]:4000", forwarded: []string{"2001:db8::1"}, ip: "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.peer
+			for _, value := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			ip := ClientIP(r, trusted)
+			if ip != test.ip {
+				t.Errorf("ClientIP = %q, want %q", ip, test.ip)
+			}
+		})
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		count int
+		valid bool
+	}{
+		{name: "empty", value: "", count: 0, valid: true},
+		{name: "several", value: "10.0.0.0/8,192.168.0.0/16", count: 2, valid: true},
+		{name: "address without mask", value: "10.0.0.1", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			networks, err := ParseCIDRs(test.value)
+			if (err == nil) != test.valid {
+				t.Fatalf("ParseCIDRs(%q) error = %v, want valid %t", test.value, err, test.valid)
+			}
+
+			if len(networks) != test.count {
+				t.Errorf("ParseCIDRs(%q) = %d networks, want %d", test.value, len(networks), test.count)
+			}
+		})
+	}
+}
+
+func TestMemoryBackendEvictsLeastRecentlyUsed(t *testing.T) {
+	backend := NewMemoryBackend(time.Hour, 2)
+	limit := Limit{Rate: 1, Burst: 1}
+	now := time.Now()
+
+	backend.Take("a", limit, now)
+	backend.Take("b", limit, now)
+	// a is used again, so b is the least recently used bucket when c arrives
+	backend.Take("a", limit, now)
+	backend.Take("c", limit, now)
+
+	if backend.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", backend.Len())
+	}
+
+	if backend.Take("a", limit, now).Allowed {
+		t.Error("bucket of a was evicted, want it kept")
+	}
+
+	if !backend.Take("b", limit, now).Allowed {
+		t.Error("bucket of b was kept, want it evicted")
+	}
+}
+
+func TestMemoryBackendForgetsIdleBuckets(t *testing.T) {
+	backend := NewMemoryBackend(time.Minute, 0)
+	limit := Limit{Rate: 1, Burst: 1}
+	now := time.Now()
+
+	backend.Take("a", limit, now)
+	backend.Take("b", limit, now.Add(30*time.Second))
+	backend.Take("c", limit, now.Add(90*time.Second))
+
+	if backend.Len() != 2 {
+		t.Errorf("Len = %d, want 2", backend.Len())
+	}
+}
+
+func TestAllow(t *testing.T) {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	limiter := NewLimiter(logger, NewMemoryBackend(time.Minute, 0), Options{Default: Limit{Rate: 1, Burst: 1}})
+
+	tests := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{name: "first request of alice", key: "user:alice", status: http.StatusOK},
+		{name: "second request of alice", key: "user:alice", status: http.StatusTooManyRequests},
+		{name: "first request of bob", key: "user:bob", status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if limiter.Allow(w, httptest.NewRequest(http.MethodGet, "/", nil), test.key) {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+
+			if w.Header().Get("RateLimit-Limit") != "1" {
+				t.Errorf("RateLimit-Limit = %q, want 1", w.Header().Get("RateLimit-Limit"))
+			}
+		})
+	}
+}
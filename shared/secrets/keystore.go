@@ -0,0 +1,136 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// KeySize is the size of a keystore key, which is stored base64 encoded.
+const KeySize = 32
+
+// Keystore is a JSON file of secrets, each encrypted with AES-GCM under the keystore key and
+// bound to its name, so values cannot be swapped between names.
+type Keystore struct {
+	path    string
+	aead    cipher.AEAD
+	secrets map[string]string
+}
+
+type keystoreFile struct {
+	Secrets map[string]string `json:"secrets"`
+}
+
+// OpenKeystore reads the keystore at path, a missing file is an empty keystore.
+func OpenKeystore(path string, key Secret) (*Keystore, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(key.Reveal())
+	if err != nil || len(rawKey) != KeySize {
+		return nil, fmt.Errorf("keystore key must be %d base64 encoded bytes", KeySize)
+	}
+
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	keystore := &Keystore{
+		path:    path,
+		aead:    aead,
+		secrets: map[string]string{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return keystore, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	file := keystoreFile{}
+
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keystore %s: %w", path, err)
+	}
+
+	if file.Secrets != nil {
+		keystore.secrets = file.Secrets
+	}
+
+	return keystore, nil
+}
+
+func (k *Keystore) Lookup(name string) (Secret, bool, error) {
+	encoded, ok := k.secrets[name]
+	if !ok {
+		return Secret{}, false, nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(ciphertext) < k.aead.NonceSize() {
+		return Secret{}, false, fmt.Errorf("keystore secret %s is malformed", name)
+	}
+
+	nonce, ciphertext := ciphertext[:k.aead.NonceSize()], ciphertext[k.aead.NonceSize():]
+
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return Secret{}, false, fmt.Errorf("failed to decrypt keystore secret %s: %w", name, err)
+	}
+
+	return New(string(plaintext)), true, nil
+}
+
+// Set encrypts the secret under name and writes the keystore.
+func (k *Keystore) Set(name string, secret Secret) error {
+	nonce := make([]byte, k.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	k.secrets[name] = base64.StdEncoding.EncodeToString(k.aead.Seal(nonce, nonce, []byte(secret.Reveal()), []byte(name)))
+
+	return k.save()
+}
+
+// Delete removes the secret called name and writes the keystore.
+func (k *Keystore) Delete(name string) error {
+	delete(k.secrets, name)
+
+	return k.save()
+}
+
+func (k *Keystore) save() error {
+	data, err := json.MarshalIndent(keystoreFile{Secrets: k.secrets}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(k.path, data, 0o600)
+}
+
+// NewKey returns a random base64 encoded keystore key.
+func NewKey() (Secret, error) {
+	key := make([]byte, KeySize)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	return New(base64.StdEncoding.EncodeToString(key)), nil
+}
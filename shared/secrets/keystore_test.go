@@ -0,0 +1,199 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestKeystore(t *testing.T) (string, Secret) {
+	t.Helper()
+
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keystore.json")
+
+	keystore, err := OpenKeystore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range map[string]string{"MONGODB_PASSWORD": "hunter2", "INGEST_TOKEN": "token"} {
+		err = keystore.Set(name, New(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path, key
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	path, key := newTestKeystore(t)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "hunter2") {
+		t.Fatalf("keystore file holds the plaintext: %s", data)
+	}
+
+	keystore, err := OpenKeystore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, ok, err := keystore.Lookup("MONGODB_PASSWORD")
+	if err != nil || !ok || secret.Reveal() != "hunter2" {
+		t.Fatalf("Lookup = %q, %v, %v, want hunter2", secret.Reveal(), ok, err)
+	}
+
+	err = keystore.Delete("MONGODB_PASSWORD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystore, err = OpenKeystore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok, err = keystore.Lookup("MONGODB_PASSWORD")
+	if err != nil || ok {
+		t.Fatalf("Lookup after Delete = %v, %v, want no secret", ok, err)
+	}
+
+	secret, ok, err = keystore.Lookup("INGEST_TOKEN")
+	if err != nil || !ok || secret.Reveal() != "token" {
+		t.Fatalf("Lookup = %q, %v, %v, want token", secret.Reveal(), ok, err)
+	}
+}
+
+func TestOpenKeystoreMissingFile(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystore, err := OpenKeystore(filepath.Join(t.TempDir(), "missing.json"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok, err := keystore.Lookup("MONGODB_PASSWORD")
+	if err != nil || ok {
+		t.Fatalf("Lookup = %v, %v, want no secret", ok, err)
+	}
+}
+
+func TestOpenKeystoreRejectsInvalidKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{name: "empty", key: ""},
+		{name: "not base64", key: "not a key"},
+		{name: "too short", key: base64.StdEncoding.EncodeToString(make([]byte, 16))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := OpenKeystore(filepath.Join(t.TempDir(), "keystore.json"), New(test.key))
+			if err == nil {
+				t.Fatal("OpenKeystore succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestKeystoreRejectsWrongKey(t *testing.T) {
+	path, _ := newTestKeystore(t)
+
+	other, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystore, err := OpenKeystore(path, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = keystore.Lookup("MONGODB_PASSWORD")
+	if err == nil {
+		t.Fatal("Lookup with the wrong key succeeded")
+	}
+}
+
+func TestKeystoreRejectsCorruptedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(secrets map[string]string)
+		raw     string
+	}{
+		{name: "not JSON", raw: "{secrets"},
+		{name: "flipped ciphertext byte", corrupt: func(secrets map[string]string) {
+			ciphertext, _ := base64.StdEncoding.DecodeString(secrets["MONGODB_PASSWORD"])
+			ciphertext[len(ciphertext)-1] ^= 1
+			secrets["MONGODB_PASSWORD"] = base64.StdEncoding.EncodeToString(ciphertext)
+		}},
+		{name: "value moved to another name", corrupt: func(secrets map[string]string) {
+			secrets["MONGODB_PASSWORD"] = secrets["INGEST_TOKEN"]
+		}},
+		{name: "not base64", corrupt: func(secrets map[string]string) {
+			secrets["MONGODB_PASSWORD"] = "!!!"
+		}},
+		{name: "shorter than a nonce", corrupt: func(secrets map[string]string) {
+			secrets["MONGODB_PASSWORD"] = base64.StdEncoding.EncodeToString([]byte("short"))
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, key := newTestKeystore(t)
+
+			if test.raw != "" {
+				err := os.WriteFile(path, []byte(test.raw), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				_, err = OpenKeystore(path, key)
+				if err == nil {
+					t.Fatal("OpenKeystore succeeded, want an error")
+				}
+
+				return
+			}
+
+			keystore, err := OpenKeystore(path, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.corrupt(keystore.secrets)
+
+			err = keystore.save()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keystore, err = OpenKeystore(path, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = keystore.Lookup("MONGODB_PASSWORD")
+			if err == nil {
+				t.Fatal("Lookup of the corrupted secret succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package secrets resolves sensitive settings such as signing keys and passwords and keeps them
+// out of logs.
+//
+// A secret called NAME is looked up, in order, in the file NAME_FILE points to (e.g. a mounted
+// Kubernetes secret), in the NAME environment variable and in the encrypted local keystore
+// SECRETS_KEYSTORE names, if any.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// Secret holds a sensitive value. It prints, logs and marshals as REDACTED, only Reveal returns
+// the value.
+type Secret struct {
+	value string
+}
+
+func New(value string) Secret {
+	return Secret{value: value}
+}
+
+// Reveal returns the value, to be handed to the code that uses it and nowhere else.
+func (s Secret) Reveal() string {
+	return s.value
+}
+
+func (s Secret) IsZero() bool {
+	return s.value == ""
+}
+
+func (s Secret) String() string {
+	if s.value == "" {
+		return ""
+	}
+
+	return redacted
+}
+
+// Format redacts the value for every verb, including %#v and %x.
+func (s Secret) Format(f fmt.State, verb rune) {
+	io.WriteString(f, s.String())
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+// Provider is a source of secrets.
+type Provider interface {
+	// Lookup returns the secret called name, ok is false when the provider does not hold it.
+	Lookup(name string) (secret Secret, ok bool, err error)
+}
+
+// EnvProvider reads the secret NAME from the NAME environment variable.
+type EnvProvider struct {
+	LookupEnv func(string) (string, bool)
+}
+
+func (p EnvProvider) Lookup(name string) (Secret, bool, error) {
+	value, ok := p.LookupEnv(name)
+	if !ok || value == "" {
+		return Secret{}, false, nil
+	}
+
+	return New(value), true, nil
+}
+
+// FileProvider reads the secret NAME from the file the NAME_FILE environment variable points to.
+// A trailing newline is not part of the secret.
+type FileProvider struct {
+	LookupEnv func(string) (string, bool)
+}
+
+func (p FileProvider) Lookup(name string) (Secret, bool, error) {
+	path, ok := p.LookupEnv(name + "_FILE")
+	if !ok || path == "" {
+		return Secret{}, false, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Secret{}, false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+
+	return New(strings.TrimRight(string(data), "\r\n")), true, nil
+}
+
+// Resolver asks its providers in order and returns the first secret found.
+type Resolver struct {
+	providers []Provider
+}
+
+func NewResolver(providers ...Provider) *Resolver {
+	return &Resolver{providers: providers}
+}
+
+func (r *Resolver) Lookup(name string) (Secret, bool, error) {
+	for _, provider := range r.providers {
+		secret, ok, err := provider.Lookup(name)
+		if err != nil || ok {
+			return secret, ok, err
+		}
+	}
+
+	return Secret{}, false, nil
+}
+
+// FromEnv returns the file, environment and, when SECRETS_KEYSTORE is set, keystore providers.
+// The keystore key is itself the secret SECRETS_KEYSTORE_KEY, from a file or the environment.
+func FromEnv(lookupEnv func(string) (string, bool)) (*Resolver, error) {
+	resolver := NewResolver(FileProvider{LookupEnv: lookupEnv}, EnvProvider{LookupEnv: lookupEnv})
+
+	path, _ := lookupEnv("SECRETS_KEYSTORE")
+	if path == "" {
+		return resolver, nil
+	}
+
+	key, ok, err := resolver.Lookup("SECRETS_KEYSTORE_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, errors.New("SECRETS_KEYSTORE needs SECRETS_KEYSTORE_KEY or SECRETS_KEYSTORE_KEY_FILE")
+	}
+
+	keystore, err := OpenKeystore(path, key)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver.providers = append(resolver.providers, keystore)
+
+	return resolver, nil
+}
@@ -0,0 +1,75 @@
+// Package server runs an HTTP server until the process is asked to stop, then drains it.
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pavr1/people_project/shared/health"
+)
+
+// Shutdown configures how Run stops.
+type Shutdown struct {
+	// Delay keeps serving after the signal while readiness fails, so load balancers stop
+	// routing new requests before the listener closes
+	Delay time.Duration
+	// Timeout bounds how long requests in flight are waited for
+	Timeout time.Duration
+	// Checker, when set, fails readiness as soon as the signal arrives
+	Checker *health.Checker
+}
+
+// Run serves until SIGINT or SIGTERM, then stops accepting connections and waits for the requests
+// in flight. It returns once the server is stopped; the caller releases its resources after it.
+func Run(log *log.Logger, server *http.Server, shutdown Shutdown) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// A second signal kills the process right away
+	stop()
+
+	log.WithField("delay", shutdown.Delay.String()).Info("Shutting down server...")
+
+	if shutdown.Checker != nil {
+		shutdown.Checker.ShutDown()
+	}
+
+	time.Sleep(shutdown.Delay)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdown.Timeout)
+	defer cancel()
+
+	err := server.Shutdown(drainCtx)
+	if err != nil {
+		log.WithError(err).Error("Failed to drain server")
+
+		return err
+	}
+
+	err = <-errs
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	log.Info("Server stopped")
+
+	return nil
+}
@@ -0,0 +1,197 @@
+// Package tracing sets up OpenTelemetry tracing of the services.
+//
+// Setup installs the exporter of the configuration and the W3C trace context propagator. Server
+// spans come from Middleware, built per route like the rest of the chain and placed outermost so
+// the request logs carry the trace:
+//
+//	api := middleware.New(tracing.Middleware(route), logging.Middleware(log), middleware.Log(log, route), ...)
+//
+// Clients of httpclient propagate the trace and record a span per call. With the none exporter no
+// span is recorded, incoming trace contexts are still passed on.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/pavr1/people_project/shared/logging"
+	"github.com/pavr1/people_project/shared/middleware"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters
+const (
+	// ExporterNone records no spans
+	ExporterNone = "none"
+	// ExporterOTLP sends spans to an OTLP/HTTP collector
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes spans to stdout, for local runs
+	ExporterStdout = "stdout"
+	// ExporterFile writes spans as JSON to a file, for local runs
+	ExporterFile = "file"
+)
+
+const instrumentation = "github.com/pavr1/people_project/shared/tracing"
+
+// Config is the tracing section of the service configurations.
+type Config struct {
+	// Exporter is none, otlp, stdout or file
+	Exporter string `mapstructure:"exporter" default:"none"`
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://otel-collector:4318. Empty uses
+	// OTEL_EXPORTER_OTLP_ENDPOINT or localhost.
+	Endpoint string `mapstructure:"endpoint"`
+	// File is where the file exporter appends spans
+	File string `mapstructure:"file" default:"traces.json"`
+	// SampleRatio is the share of traces started here that are recorded, requests with a
+	// trace context follow the sampling decision of their caller
+	SampleRatio float64 `mapstructure:"sample_ratio" default:"1"`
+}
+
+// Validate checks the settings the loader cannot check by their type.
+func (c Config) Validate() error {
+	switch c.Exporter {
+	case ExporterNone, ExporterOTLP, ExporterStdout, ExporterFile:
+	default:
+		return fmt.Errorf("exporter must be %s, %s, %s or %s", ExporterNone, ExporterOTLP, ExporterStdout, ExporterFile)
+	}
+
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return errors.New("sample_ratio must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// Setup installs the tracer provider of service and the propagator. The returned function flushes
+// the spans not exported yet, call it on shutdown.
+func Setup(ctx context.Context, log *log.Logger, service string, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if config.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var file *os.File
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch config.Exporter {
+	case ExporterOTLP:
+		options := []otlptracehttp.Option{}
+		if config.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(config.Endpoint))
+		}
+
+		exporter, err = otlptracehttp.New(ctx, options...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	case ExporterFile:
+		file, err = os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	log.WithField("exporter", config.Exporter).Info("Tracing enabled")
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+
+		return err
+	}, nil
+}
+
+// Middleware records a server span per request, named by its method and route, continuing the
+// trace of the caller.
+func Middleware(route middleware.RouteFunc) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := route(r)
+
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := otel.Tracer(instrumentation).Start(ctx, r.Method+" "+name,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.HTTPRoute(name),
+					semconv.URLPath(r.URL.Path),
+				),
+			)
+			defer span.End()
+
+			rw := middleware.NewResponseWriter(w)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+
+			status := rw.Status()
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
+
+// RecordError marks span as failed by err, if any, and returns err.
+func RecordError(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+// LogHook adds the trace_id and span_id of the entry context to the entries logged with
+// WithContext.
+type LogHook struct{}
+
+func (LogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (LogHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+
+	spanContext := trace.SpanContextFromContext(entry.Context)
+	if !spanContext.IsValid() {
+		return nil
+	}
+
+	entry.Data[logging.FieldTraceID] = spanContext.TraceID().String()
+	entry.Data[logging.FieldSpanID] = spanContext.SpanID().String()
+
+	return nil
+}